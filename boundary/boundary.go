@@ -0,0 +1,92 @@
+package boundary
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"housing/geo"
+	"housing/transaction"
+)
+
+// Property keys of the MOI 村里界圖 and 最小統計區 datasets.
+const (
+	VillageCodeKey  = "VILLCODE"
+	VillageNameKey  = "VILLNAME"
+	StatAreaCodeKey = "CODEBASE"
+)
+
+// A Layer is a set of boundaries, such as 村里 or 最小統計區, indexed for point lookups.
+type Layer struct {
+	CodeKey string
+	NameKey string
+	index   *geo.Index
+}
+
+// LoadLayer reads a GeoJSON file or, if fname ends with .shp, a shapefile,
+// and indexes it in a grid of cellSize degrees.
+func LoadLayer(fname string, cellSize float64, codeKey, nameKey string) (*Layer, error) {
+	var features []geo.Feature
+	var err error
+	if strings.ToLower(filepath.Ext(fname)) == ".shp" {
+		features, err = geo.ReadShapefile(fname)
+	} else {
+		features, err = geo.ReadFeaturesFile(fname)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	index, err := geo.NewIndex(features, cellSize)
+	if err != nil {
+		return nil, errors.Wrap(err, fname)
+	}
+	l := Layer{
+		CodeKey: codeKey,
+		NameKey: nameKey,
+		index:   index,
+	}
+	return &l, nil
+}
+
+// Locate returns the code and name of the boundary containing lat, lng.
+func (l *Layer) Locate(lat, lng float64) (string, string, bool) {
+	f := l.index.Locate(geo.Point{Lat: lat, Lng: lng})
+	if f == nil {
+		return "", "", false
+	}
+	name := ""
+	if l.NameKey != "" {
+		name = f.StringProperty(l.NameKey)
+	}
+	return f.StringProperty(l.CodeKey), name, true
+}
+
+// Joiner sets the 村里 and 最小統計區 of transactions from their locations.
+// Either layer may be nil.
+type Joiner struct {
+	Villages  *Layer
+	StatAreas *Layer
+}
+
+// Join sets the boundary codes of ts, and reports whether ts is inside every layer.
+// Transactions that are not located by their address are left untouched,
+// since a 鄉鎮市區 centroid says nothing about its 村里.
+func (j *Joiner) Join(ts *transaction.Transaction) bool {
+	if ts.LocationQuality != transaction.LocationGeocoded || (ts.Lat == 0 && ts.Lng == 0) {
+		return false
+	}
+	ok := true
+	if j.Villages != nil {
+		code, name, found := j.Villages.Locate(ts.Lat, ts.Lng)
+		ts.VillageCode = code
+		ts.VillageName = name
+		ok = ok && found
+	}
+	if j.StatAreas != nil {
+		code, _, found := j.StatAreas.Locate(ts.Lat, ts.Lng)
+		ts.StatAreaCode = code
+		ok = ok && found
+	}
+	return ok
+}
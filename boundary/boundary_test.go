@@ -0,0 +1,85 @@
+package boundary
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"housing/transaction"
+)
+
+// villages is a GeoJSON of two 村里 side by side, the first with a hole.
+const villages = `{"type": "FeatureCollection", "features": [
+{"type": "Feature", "properties": {"VILLCODE": "63000010001", "VILLNAME": "西村"},
+ "geometry": {"type": "Polygon", "coordinates": [[[121, 25], [121.5, 25], [121.5, 25.5], [121, 25.5], [121, 25]],
+  [[121.2, 25.2], [121.3, 25.2], [121.3, 25.3], [121.2, 25.3], [121.2, 25.2]]]}},
+{"type": "Feature", "properties": {"VILLCODE": "63000010002", "VILLNAME": "東村"},
+ "geometry": {"type": "MultiPolygon", "coordinates": [[[[121.5, 25], [122, 25], [122, 25.5], [121.5, 25.5], [121.5, 25]]]]}},
+{"type": "Feature", "properties": {"VILLCODE": "63000010003"}, "geometry": null}
+]}`
+
+func loadVillages(t *testing.T) *Layer {
+	fname := filepath.Join(t.TempDir(), "villages.geojson")
+	if err := ioutil.WriteFile(fname, []byte(villages), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadLayer(fname, 0.1, VillageCodeKey, VillageNameKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLocate(t *testing.T) {
+	l := loadVillages(t)
+	tests := []struct {
+		lat, lng float64
+		code     string
+		name     string
+		found    bool
+	}{
+		{25.1, 121.1, "63000010001", "西村", true},
+		{25.1, 121.9, "63000010002", "東村", true},
+		{25.25, 121.25, "", "", false},
+		{24.9, 121.1, "", "", false},
+	}
+	for _, tt := range tests {
+		code, name, found := l.Locate(tt.lat, tt.lng)
+		if code != tt.code || name != tt.name || found != tt.found {
+			t.Errorf("Locate(%f, %f) = %s %s %v, want %s %s %v", tt.lat, tt.lng, code, name, found, tt.code, tt.name, tt.found)
+		}
+	}
+}
+
+func TestLoadLayerErrors(t *testing.T) {
+	if _, err := LoadLayer(filepath.Join(t.TempDir(), "missing.geojson"), 0.1, VillageCodeKey, ""); err == nil {
+		t.Errorf("no error of missing file")
+	}
+	fname := filepath.Join(t.TempDir(), "villages.geojson")
+	if err := ioutil.WriteFile(fname, []byte(villages), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadLayer(fname, 0, VillageCodeKey, ""); err == nil {
+		t.Errorf("no error of cell size 0")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	j := Joiner{Villages: loadVillages(t)}
+	ts := transaction.Transaction{Lat: 25.1, Lng: 121.9}
+	if !j.Join(&ts) || ts.VillageCode != "63000010002" || ts.VillageName != "東村" {
+		t.Errorf("joined %+v", ts)
+	}
+
+	// Transactions outside every boundary have their codes cleared.
+	ts.Lat = 24.9
+	if j.Join(&ts) || ts.VillageCode != "" || ts.VillageName != "" {
+		t.Errorf("joined %+v outside", ts)
+	}
+
+	// Centroids are not joined.
+	ts = transaction.Transaction{Lat: 25.1, Lng: 121.9, LocationQuality: transaction.LocationCentroid}
+	if j.Join(&ts) || ts.VillageCode != "" {
+		t.Errorf("joined centroid %+v", ts)
+	}
+}
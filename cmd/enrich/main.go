@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/boundary"
+	"housing/transaction"
+)
+
+var (
+	infile          string
+	villagefile     string
+	villageCodeKey  string
+	villageNameKey  string
+	statAreafile    string
+	statAreaCodeKey string
+	cellSize        float64
+)
+
+func init() {
+	flag.StringVar(&infile, "infile", "", "input file containing the parsed transactions")
+	flag.StringVar(&villagefile, "villagefile", "", "GeoJSON or shapefile of 村里 boundaries")
+	flag.StringVar(&villageCodeKey, "villageCodeKey", boundary.VillageCodeKey, "property of the 村里 code in villagefile")
+	flag.StringVar(&villageNameKey, "villageNameKey", boundary.VillageNameKey, "property of the 村里 name in villagefile")
+	flag.StringVar(&statAreafile, "statAreafile", "", "GeoJSON or shapefile of 最小統計區 boundaries")
+	flag.StringVar(&statAreaCodeKey, "statAreaCodeKey", boundary.StatAreaCodeKey, "property of the 最小統計區 code in statAreafile")
+	flag.Float64Var(&cellSize, "cellSize", 0.01, "cell size in degrees of the spatial index")
+}
+
+func enrichFile(fname string, joiner *boundary.Joiner) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return errors.Wrap(err, "os.Open")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	numRows, numJoined := 0, 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		ts := transaction.Transaction{}
+		if err := json.Unmarshal(scanner.Bytes(), &ts); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("json.Unmarshal line %d", numRows))
+		}
+		numRows++
+		if joiner.Join(&ts) {
+			numJoined++
+		} else {
+			glog.V(1).Infof("not joined %s %f,%f", ts.A編號, ts.Lat, ts.Lng)
+		}
+
+		b, err := json.Marshal(ts)
+		if err != nil {
+			return errors.Wrap(err, "json.Marshal")
+		}
+		fmt.Printf("%s\n", b)
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "scanner.Err")
+	}
+	glog.Infof("joined %d of %d rows", numJoined, numRows)
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+
+	joiner := &boundary.Joiner{}
+	if villagefile != "" {
+		l, err := boundary.LoadLayer(villagefile, cellSize, villageCodeKey, villageNameKey)
+		if err != nil {
+			glog.Fatalf("%+v", err)
+		}
+		joiner.Villages = l
+	}
+	if statAreafile != "" {
+		// 最小統計區 are much smaller than 村里, hence the finer grid.
+		l, err := boundary.LoadLayer(statAreafile, cellSize/10, statAreaCodeKey, "")
+		if err != nil {
+			glog.Fatalf("%+v", err)
+		}
+		joiner.StatAreas = l
+	}
+
+	if err := enrichFile(infile, joiner); err != nil {
+		glog.Errorf("%+v", err)
+	}
+}
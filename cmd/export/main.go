@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/geo"
+	"housing/transaction"
+)
+
+var (
+	infile  string
+	outfile string
+	format  string
+	keys    string
+	inexact string
+)
+
+// Formats of outfile.
+const (
+	formatGeoJSON = "geojson"
+	formatKML     = "kml"
+)
+
+// Key mappings of the properties.
+const (
+	keysChinese = "zh"
+	keysEnglish = "en"
+)
+
+// Handling of transactions located at centroids or without locations.
+const (
+	inexactExclude = "exclude"
+	inexactMark    = "mark"
+)
+
+// labelKey is the property of the transaction.LocationLabel of marked transactions.
+const labelKey = "LocationLabel"
+
+func init() {
+	flag.StringVar(&infile, "infile", "", "output file of cmd/parse or cmd/enrich")
+	flag.StringVar(&outfile, "outfile", "", "output file, written only after all transactions are exported")
+	flag.StringVar(&format, "format", "", "geojson or kml, defaults to the extension of outfile")
+	flag.StringVar(&keys, "keys", keysChinese, "property keys, zh for the JSON keys such as 總價元 or en for English such as PriceNTD")
+	flag.StringVar(&inexact, "inexact", inexactMark,
+		"exclude the transactions located at the centroids of 鄉鎮市區 or without locations, or mark them with the "+labelKey+" property")
+}
+
+// featureWriter writes features in a format.
+type featureWriter interface {
+	write(ts transaction.Transaction, p *geo.Point, props geo.Properties) error
+	Close() error
+}
+
+type geoJSONWriter struct {
+	*geo.FeatureCollectionWriter
+}
+
+func (w geoJSONWriter) write(ts transaction.Transaction, p *geo.Point, props geo.Properties) error {
+	return w.WritePoint(p, props)
+}
+
+type kmlWriter struct {
+	*geo.KMLWriter
+}
+
+// KML styles of marked transactions.
+var kmlStyles = []geo.KMLStyle{
+	{ID: transaction.LabelCentroid, Color: "ff00a5ff"},
+	{ID: transaction.LabelMissing, Color: "ff0000ff"},
+}
+
+func (w kmlWriter) write(ts transaction.Transaction, p *geo.Point, props geo.Properties) error {
+	styleID := ""
+	if label := ts.LocationLabel(); label != transaction.LabelGeocoded {
+		styleID = label
+	}
+	return w.WritePlacemark(ts.A土地區段位置或建物區門牌, p, styleID, props)
+}
+
+// properties returns the fields of ts that are given, keyed by the keys mapping.
+// Zero numbers such as 0 rooms are kept.
+func properties(ts transaction.Transaction) geo.Properties {
+	props := geo.Properties{}
+	vals := ts.Values()
+	for i, key := range transaction.Keys() {
+		switch v := vals[i].(type) {
+		case string:
+			if v == "" {
+				continue
+			}
+		case int64:
+			// The int64 fields are dates, which cmd/parse sets to -1 if they are empty.
+			if v == -1 {
+				continue
+			}
+		}
+		if en, ok := transaction.EnglishKeys[key]; ok && keys == keysEnglish {
+			key = en
+		}
+		props = append(props, geo.Property{Key: key, Value: vals[i]})
+	}
+	return props
+}
+
+func export(w io.Writer) (map[string]int, error) {
+	var fw featureWriter
+	switch format {
+	case formatGeoJSON:
+		fw = geoJSONWriter{geo.NewFeatureCollectionWriter(w)}
+	case formatKML:
+		name := strings.TrimSuffix(filepath.Base(infile), filepath.Ext(infile))
+		fw = kmlWriter{geo.NewKMLWriter(w, name, kmlStyles...)}
+	}
+
+	counts := make(map[string]int)
+	err := transaction.ScanFile(infile, func(line int, ts transaction.Transaction) error {
+		label := ts.LocationLabel()
+		if label != transaction.LabelGeocoded && inexact == inexactExclude {
+			counts["excluded"]++
+			return nil
+		}
+		counts[label]++
+
+		props := properties(ts)
+		if inexact == inexactMark {
+			props = append(props, geo.Property{Key: labelKey, Value: label})
+		}
+		var p *geo.Point
+		if label != transaction.LabelMissing {
+			p = &geo.Point{Lat: ts.Lat, Lng: ts.Lng}
+		}
+		if err := fw.write(ts, p, props); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("line %d", line))
+		}
+		return nil
+	})
+	if err != nil {
+		return counts, err
+	}
+	return counts, fw.Close()
+}
+
+// exportFile writes outfile through a temporary file, so that it is either complete or absent.
+func exportFile() (map[string]int, error) {
+	tmp := outfile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.Create")
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	counts, err := export(bw)
+	if err != nil {
+		return counts, err
+	}
+	if err := bw.Flush(); err != nil {
+		return counts, errors.Wrap(err, "Flush")
+	}
+	if err := f.Close(); err != nil {
+		return counts, errors.Wrap(err, "Close")
+	}
+	if err := os.Rename(tmp, outfile); err != nil {
+		return counts, errors.Wrap(err, "os.Rename")
+	}
+	return counts, nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	if infile == "" || outfile == "" {
+		glog.Fatalf("infile and outfile are required")
+	}
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(outfile)), ".")
+		if format == "json" {
+			format = formatGeoJSON
+		}
+	}
+	if format != formatGeoJSON && format != formatKML {
+		glog.Fatalf("unknown format %s", format)
+	}
+	if keys != keysChinese && keys != keysEnglish {
+		glog.Fatalf("unknown keys %s", keys)
+	}
+	if inexact != inexactExclude && inexact != inexactMark {
+		glog.Fatalf("unknown inexact %s", inexact)
+	}
+
+	counts, err := exportFile()
+	fmt.Fprintf(os.Stderr, "geocoded: %d, centroid: %d, missing: %d, excluded: %d\n",
+		counts[transaction.LabelGeocoded], counts[transaction.LabelCentroid], counts[transaction.LabelMissing], counts["excluded"])
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/util"
+	"housing/util/jinma"
+	"housing/util/journal"
+)
+
+var (
+	jinmaFlags  *jinma.Flags
+	outfile     string
+	journalfile string
+	numWorkers  int
+	partitions  int
+)
+
+func init() {
+	jinmaFlags = jinma.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&outfile, "outfile", "", "output file of the messages, written only after all partitions are scanned")
+	flag.StringVar(&journalfile, "journal", "", "checkpoint journal of the scanned partitions, defaults to outfile.journal")
+	flag.IntVar(&numWorkers, "workers", 8, "number of partitions scanned concurrently")
+	flag.IntVar(&partitions, "partitions", jinma.DefaultPartitions, "number of partitions of the messages")
+}
+
+// dumper dumps the messages of an app to outfile, writing the messages of each partition to its part file first.
+type dumper struct {
+	client     *jinma.Client
+	outfile    string
+	partitions int
+	numWorkers int
+}
+
+func (d *dumper) partsDir() string {
+	return d.outfile + ".parts"
+}
+
+func (d *dumper) partFile(partition int) string {
+	return filepath.Join(d.partsDir(), fmt.Sprintf("partition-%04d.jsonl", partition))
+}
+
+// scanPartition appends the messages of partition to its part file,
+// resuming from the last page recorded in jnl.
+func (d *dumper) scanPartition(ctx context.Context, appID string, partition int, jnl *journal.Journal) error {
+	e, ok := jnl.Line(partition)
+	if ok && e.Action == journal.ActionScanned {
+		return nil
+	}
+
+	f, err := os.OpenFile(d.partFile(partition), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile")
+	}
+	defer f.Close()
+	// Discard the messages written after the last checkpoint.
+	offset := e.Offset
+	if err := f.Truncate(offset); err != nil {
+		return errors.Wrap(err, "Truncate")
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+
+	return d.client.ScanPartitionPages(ctx, appID, partition, e.ESK, func(msgs []jinma.Msg, esk string) error {
+		var b bytes.Buffer
+		for _, msg := range msgs {
+			line, err := json.Marshal(msg)
+			if err != nil {
+				return errors.Wrap(err, "json.Marshal")
+			}
+			b.Write(line)
+			b.WriteByte('\n')
+		}
+		n, err := f.Write(b.Bytes())
+		if err != nil {
+			return errors.Wrap(err, "Write")
+		}
+		if err := f.Sync(); err != nil {
+			return errors.Wrap(err, "Sync")
+		}
+		offset += int64(n)
+
+		action := journal.ActionScanning
+		if esk == "" {
+			action = journal.ActionScanned
+		}
+		if err := jnl.Append(journal.Entry{Line: partition, Action: action, ESK: esk, Offset: offset}); err != nil {
+			return errors.Wrap(err, "journal.Append")
+		}
+		return nil
+	})
+}
+
+// scanPartitions scans all partitions with numWorkers workers, and returns the partitions that failed.
+func (d *dumper) scanPartitions(ctx context.Context, appID string, jnl *journal.Journal) []int {
+	todo := make(chan int)
+	go func() {
+		defer close(todo)
+		for p := 0; p < d.partitions; p++ {
+			select {
+			case todo <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	failed := []int{}
+	var wg sync.WaitGroup
+	for w := 0; w < d.numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range todo {
+				if err := d.scanPartition(ctx, appID, p, jnl); err != nil {
+					glog.Errorf("partition %d: %+v", p, err)
+					mu.Lock()
+					failed = append(failed, p)
+					mu.Unlock()
+					continue
+				}
+				glog.Infof("finished scanning partition %d", p)
+			}
+		}()
+	}
+	wg.Wait()
+	sort.Ints(failed)
+	return failed
+}
+
+// merge concatenates the part files into outfile.
+// outfile is written to a temporary file first and renamed, so that it is either complete or absent.
+func (d *dumper) merge() error {
+	tmp := d.outfile + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "os.Create")
+	}
+	defer out.Close()
+	for p := 0; p < d.partitions; p++ {
+		f, err := os.Open(d.partFile(p))
+		if err != nil {
+			return errors.Wrap(err, "os.Open")
+		}
+		_, err = io.Copy(out, f)
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("io.Copy partition %d", p))
+		}
+	}
+	if err := out.Sync(); err != nil {
+		return errors.Wrap(err, "Sync")
+	}
+	if err := out.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+	if err := os.Rename(tmp, d.outfile); err != nil {
+		return errors.Wrap(err, "os.Rename")
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+	if outfile == "" {
+		glog.Fatalf("no outfile")
+	}
+	client, err := jinmaFlags.Client(jinma.WithPartitions(partitions))
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	d := &dumper{
+		client:     client,
+		outfile:    outfile,
+		partitions: partitions,
+		numWorkers: numWorkers,
+	}
+
+	// Get the the appID of our token.
+	me, err := d.client.Me(ctx)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+
+	if journalfile == "" {
+		journalfile = outfile + ".journal"
+	}
+	jnl, err := journal.Open(journalfile)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	if err := os.MkdirAll(d.partsDir(), 0755); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+
+	// Get all messages.
+	failed := d.scanPartitions(ctx, me.App.ID, jnl)
+	jnl.Close()
+	if ctx.Err() != nil {
+		glog.Fatalf("interrupted, rerun to resume the scan")
+	}
+	if len(failed) > 0 {
+		glog.Fatalf("failed partitions %v, rerun to resume the scan", failed)
+	}
+
+	if err := d.merge(); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	if err := os.RemoveAll(d.partsDir()); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	if err := os.Remove(journalfile); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+}
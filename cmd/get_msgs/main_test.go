@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"housing/util"
+	"housing/util/jinma"
+	"housing/util/jinma/jinmatest"
+	"housing/util/journal"
+)
+
+const (
+	testToken = "test-token"
+	testApp   = "app"
+)
+
+// setup starts a fake Jinma server with n messages spread over several pages of every partition,
+// and returns it with a dumper of its messages.
+func setup(t *testing.T, n int, opts ...jinma.Option) (*jinmatest.Server, *dumper) {
+	s := jinmatest.NewServer()
+	t.Cleanup(s.Close)
+	s.Partitions = 4
+	s.PageSize = 2
+	s.AddUser(testToken, jinma.User{ID: "user"}, jinma.App{ID: testApp})
+	ctx := context.Background()
+	c := s.Client(testToken)
+	for i := 0; i < n; i++ {
+		if _, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, fmt.Sprintf("RPTEST%04d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy := util.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+	opts = append([]jinma.Option{jinma.WithPartitions(s.Partitions), jinma.WithRetryPolicy(policy)}, opts...)
+	d := &dumper{
+		client:     s.Client(testToken, opts...),
+		outfile:    filepath.Join(t.TempDir(), "msgs.jsonl"),
+		partitions: s.Partitions,
+		numWorkers: 2,
+	}
+	if err := os.MkdirAll(d.partsDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return s, d
+}
+
+// run scans the partitions with d and the journal of its outfile, as a run of cmd/get_msgs does, and returns the failed partitions.
+func run(t *testing.T, d *dumper) []int {
+	jnl, err := journal.Open(d.outfile + ".journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jnl.Close()
+	return d.scanPartitions(context.Background(), testApp, jnl)
+}
+
+// checkOutfile checks that the outfile of d has every message of s exactly once.
+func checkOutfile(t *testing.T, s *jinmatest.Server, d *dumper) {
+	if err := d.merge(); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	err := jinma.ScanDump(d.outfile, func(rowID int, msg jinma.Msg) error {
+		got = append(got, msg.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{}
+	for _, msg := range s.Msgs() {
+		want = append(want, msg.ID)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got messages %v, want %v", got, want)
+	}
+}
+
+func TestScanPartitions(t *testing.T) {
+	s, d := setup(t, 20)
+	if failed := run(t, d); len(failed) != 0 {
+		t.Fatalf("failed partitions %v", failed)
+	}
+	checkOutfile(t, s, d)
+}
+
+func TestScanPartitionsServerError(t *testing.T) {
+	s, d := setup(t, 20)
+	// Scans are idempotent, so server errors are retried.
+	s.FailNext("/MsgsByAppUser", 2, 503)
+	if failed := run(t, d); len(failed) != 0 {
+		t.Fatalf("failed partitions %v", failed)
+	}
+	checkOutfile(t, s, d)
+}
+
+// failingTransport fails the requests whose numbers, counting from 1, are in failAt.
+type failingTransport struct {
+	mu     sync.Mutex
+	n      int
+	failAt map[int]bool
+}
+
+func (ft *failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ft.mu.Lock()
+	ft.n++
+	fail := ft.failAt[ft.n]
+	ft.mu.Unlock()
+	if fail {
+		return nil, fmt.Errorf("connection reset")
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestScanPartitionsResume(t *testing.T) {
+	// The failures after the first pages of partitions leave partially written part files.
+	ft := &failingTransport{failAt: map[int]bool{3: true, 4: true, 5: true}}
+	s, d := setup(t, 20, jinma.WithHTTPClient(&http.Client{Transport: ft}), jinma.WithRetryPolicy(util.RetryPolicy{MaxAttempts: 1}))
+	if failed := run(t, d); len(failed) == 0 {
+		t.Fatalf("no failed partitions")
+	}
+	if failed := run(t, d); len(failed) != 0 {
+		t.Fatalf("failed partitions %v after rerun", failed)
+	}
+	checkOutfile(t, s, d)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing"
+	"housing/gazetteer"
+	"housing/store"
+	"housing/transaction"
+	"housing/util"
+)
+
+var (
+	dbfile        string
+	infile        string
+	dirname       string
+	cachefile     string
+	gazetteerfile string
+	batchSize     int
+)
+
+func init() {
+	flag.StringVar(&dbfile, "db", "", "SQLite file of the store, created if missing")
+	flag.StringVar(&infile, "infile", "", "output file of cmd/parse or cmd/enrich")
+	flag.StringVar(&dirname, "dirname", "", "directory containing 實價登錄 files, parsed without calling the geocoding API")
+	flag.StringVar(&cachefile, "cachefile", "", "cache file for prefetched geocoding results, for locating the transactions in dirname")
+	flag.StringVar(&gazetteerfile, "gazetteerfile", "", "GeoJSON file of 鄉鎮市區 boundaries, for locating the transactions in dirname at centroids, defaults to the gazetteer bundled by go generate housing/gazetteer")
+	flag.IntVar(&batchSize, "batchSize", 1000, "number of transactions per database transaction")
+}
+
+// loader upserts transactions into a store in batches.
+type loader struct {
+	st    *store.Store
+	batch *store.Batch
+	n     int
+	// counts are the results of the committed batches, and pending those of the current batch.
+	counts  map[store.Result]int
+	pending map[store.Result]int
+}
+
+func (l *loader) upsert(ctx context.Context, ts transaction.Transaction) error {
+	if l.batch == nil {
+		b, err := l.st.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		l.batch = b
+	}
+	result, err := l.batch.Upsert(ctx, ts)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Upsert %s", ts.A編號))
+	}
+	l.pending[result]++
+	l.n++
+	if l.n%batchSize == 0 {
+		return l.commit()
+	}
+	return nil
+}
+
+func (l *loader) commit() error {
+	if l.batch == nil {
+		return nil
+	}
+	err := l.batch.Commit()
+	l.batch = nil
+	if err != nil {
+		return err
+	}
+	for r, n := range l.pending {
+		l.counts[r] += n
+	}
+	l.pending = make(map[store.Result]int)
+	return nil
+}
+
+func (l *loader) rollback() {
+	if l.batch == nil {
+		return
+	}
+	if err := l.batch.Rollback(); err != nil {
+		glog.Errorf("%+v", err)
+	}
+	l.batch = nil
+}
+
+func loadFile(ctx context.Context, l *loader) error {
+	return transaction.ScanFile(infile, func(line int, ts transaction.Transaction) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Older output of cmd/parse has no CountyCode, which is then only known from addresses that are not land 區段.
+		if ts.CountyCode == "" {
+			ts.CountyCode = housing.CountyCode(ts.A土地區段位置或建物區門牌)
+		}
+		if ts.CountyCode == "" {
+			return fmt.Errorf("line %d: no county of %s %s, rerun cmd/parse or load with -dirname", line, ts.A編號, ts.A土地區段位置或建物區門牌)
+		}
+		if err := l.upsert(ctx, ts); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("line %d", line))
+		}
+		return nil
+	})
+}
+
+func loadDir(ctx context.Context, l *loader) error {
+	var precisionMeters float64 = 999999
+	geocoder := housing.NewGeocoder("", precisionMeters)
+	geocoder.CacheOnly = true
+	if cachefile != "" {
+		if err := geocoder.PopulateCache(cachefile); err != nil {
+			return errors.Wrap(err, "PopulateCache")
+		}
+	}
+	gz, err := gazetteer.LoadOrDefault(gazetteerfile)
+	if err != nil {
+		return errors.Wrap(err, "gazetteer.LoadOrDefault")
+	}
+	geocoder.Gazetteer = gz
+	defer func() {
+		glog.Infof("%s", geocoder.Report())
+	}()
+
+	return housing.ScanDir(dirname, func(fname string, rowID int, row []string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		countyCode := housing.CountyCodeFromFilename(fname)
+		ts, err := housing.ParseRowContext(ctx, countyCode, row, geocoder)
+		if err != nil {
+			switch errors.Cause(err).(type) {
+			case *housing.GeocodeNoResultsError, *housing.GeocodeOutOfBoundsError:
+				// Keep the transaction without a location.
+				ts, err = housing.ParseRowContext(ctx, countyCode, row, nil)
+			}
+		}
+		if err != nil {
+			glog.Errorf("skipping %s:%d %v", fname, rowID, err)
+			return nil
+		}
+		if err := l.upsert(ctx, *ts); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("%s:%d", fname, rowID))
+		}
+		return nil
+	})
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+	if dbfile == "" {
+		glog.Fatalf("no db")
+	}
+	if (infile == "") == (dirname == "") {
+		glog.Fatalf("exactly one of infile and dirname is required")
+	}
+
+	st, err := store.Open(dbfile)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	defer st.Close()
+
+	l := &loader{st: st, counts: make(map[store.Result]int), pending: make(map[store.Result]int)}
+	if infile != "" {
+		err = loadFile(ctx, l)
+	} else {
+		err = loadDir(ctx, l)
+	}
+	if err == nil {
+		err = l.commit()
+	}
+	fmt.Fprintf(os.Stderr, "inserted: %d, updated: %d, unchanged: %d\n",
+		l.counts[store.Inserted], l.counts[store.Updated], l.counts[store.Unchanged])
+	if err != nil {
+		// Upserts are idempotent, so rerunning loads the rolled back batch again.
+		l.rollback()
+		st.Close()
+		glog.Fatalf("%+v", err)
+	}
+}
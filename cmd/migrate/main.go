@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/migration"
+	"housing/publish"
+	"housing/transaction"
+	"housing/util"
+	"housing/util/jinma"
+	"housing/util/journal"
+)
+
+var (
+	jinmaFlags     *jinma.Flags
+	infile         string
+	migrationName  string
+	list           bool
+	dryRun         bool
+	journalfile    string
+	bodyTemplate   string
+	requestsPerSec float64
+
+	client   *jinma.Client
+	limiter  *util.RateLimiter
+	renderer *publish.BodyRenderer
+)
+
+func init() {
+	jinmaFlags = jinma.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&infile, "infile", "", "dump of the published messages by cmd/get_msgs")
+	flag.StringVar(&migrationName, "migration", "", "name of the migration to apply")
+	flag.BoolVar(&list, "list", false, "list the migrations")
+	flag.BoolVar(&dryRun, "dryRun", true, "only print the changes")
+	flag.StringVar(&journalfile, "journal", "", "checkpoint journal of the migrated messages, defaults to infile.migration.journal")
+	flag.StringVar(&bodyTemplate, "bodyTemplate", "", "text/template file of the summaries in the message bodies, defaults to publish.DefaultBodyTemplate")
+	flag.Float64Var(&requestsPerSec, "rps", 10, "maximum requests per second to Jinma, 0 for no limit")
+}
+
+// changes returns the differences between the migrated m and its original message.
+func changes(m *migration.Msg, body []byte) []string {
+	c := []string{}
+	if string(body) != m.Orig.Body {
+		c = append(c, "body")
+	}
+	if m.SKF64 != m.Orig.SKF64 {
+		c = append(c, fmt.Sprintf("sortkey %f->%f", m.Orig.SKF64, m.SKF64))
+	}
+	if moved(m) {
+		c = append(c, fmt.Sprintf("location %f,%f->%f,%f", m.Orig.Lat, m.Orig.Lng, m.Transaction.Lat, m.Transaction.Lng))
+	}
+	return c
+}
+
+func moved(m *migration.Msg) bool {
+	return m.Transaction.Lat != m.Orig.Lat || m.Transaction.Lng != m.Orig.Lng
+}
+
+// apply publishes the migrated m of row i, and journals the resulting message.
+func apply(ctx context.Context, i int, m *migration.Msg, body []byte, jnl *journal.Journal) (string, error) {
+	if err := limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	if !moved(m) {
+		msg, err := publish.Update(ctx, client, m.Orig.ID, body, m.SKF64, nil)
+		if err != nil {
+			return "", err
+		}
+		return journal.ActionUpdated, appendEntry(jnl, i, m.Orig.CustomID, msg.ID, journal.ActionUpdated)
+	}
+
+	// MsgUpdate cannot move messages, so a new message is created and the old one deleted.
+	// The new message is journaled first, so that a rerun finishes the deletion instead of creating it again.
+	msg, err := publish.Create(ctx, client, m.Transaction, body, m.SKF64, m.Orig.Hashtags)
+	if err != nil {
+		return "", errors.Wrap(err, "publish.Create")
+	}
+	if err := appendEntry(jnl, i, m.Orig.CustomID, msg.ID, journal.ActionCreated); err != nil {
+		return "", err
+	}
+	return journal.ActionReplaced, deleteReplaced(ctx, i, m.Orig, msg.ID, jnl)
+}
+
+// deleteReplaced deletes orig, the message of row i replaced by message msgID.
+func deleteReplaced(ctx context.Context, i int, orig jinma.Msg, msgID string, jnl *journal.Journal) error {
+	if err := limiter.Wait(ctx); err != nil {
+		return err
+	}
+	// The previous run may have deleted orig before it stopped.
+	if err := client.MsgDelete(ctx, orig.ID); err != nil && !jinma.IsNotFound(err) {
+		return errors.Wrap(err, fmt.Sprintf("client.MsgDelete %s replaced by %s", orig.ID, msgID))
+	}
+	return appendEntry(jnl, i, orig.CustomID, msgID, journal.ActionReplaced)
+}
+
+func appendEntry(jnl *journal.Journal, i int, customID, msgID, action string) error {
+	err := jnl.Append(journal.Entry{Line: i, CustomID: customID, MsgID: msgID, Action: action})
+	return errors.Wrap(err, "journal.Append")
+}
+
+func migrate(ctx context.Context, mig migration.Migration, jnl *journal.Journal) error {
+	fn := mig.New()
+	counts := make(map[string]int)
+	err := jinma.ScanDump(infile, func(i int, orig jinma.Msg) error {
+		if err := ctx.Err(); err != nil {
+			glog.Infof("interrupted before row %d", i)
+			return err
+		}
+		ts, err := publish.DecodeBody(orig)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("publish.DecodeBody row %d %s", i, orig.ID))
+		}
+		m := &migration.Msg{Orig: orig, Transaction: ts, SKF64: orig.SKF64}
+		if err := fn(m); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("migration %s row %d %s", mig.Name, i, orig.ID))
+		}
+
+		if transaction.BodyVersion(orig.Body) >= mig.Version {
+			counts["current"]++
+			return nil
+		}
+		// Resume from where the previous run stopped.
+		if jnl != nil {
+			if e, ok := jnl.Line(i); ok && e.CustomID == orig.CustomID {
+				if e.Action != journal.ActionCreated {
+					counts["journaled"]++
+					return nil
+				}
+				if err := deleteReplaced(ctx, i, orig, e.MsgID, jnl); err != nil {
+					return errors.Wrap(err, fmt.Sprintf("row %d", i))
+				}
+				counts[journal.ActionReplaced]++
+				return nil
+			}
+		}
+
+		body, err := renderer.VersionedMsgBody(m.Transaction, mig.Version)
+		if err != nil {
+			return errors.Wrap(err, "renderer.VersionedMsgBody")
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\n", i, orig.ID, orig.CustomID, strings.Join(changes(m, body), ","))
+		if dryRun {
+			counts["planned"]++
+			return nil
+		}
+
+		action, err := apply(ctx, i, m, body, jnl)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("apply row %d %s", i, orig.ID))
+		}
+		counts[action]++
+		return nil
+	})
+	fmt.Fprintf(os.Stderr, "current: %d, journaled: %d, planned: %d, updated: %d, recreated: %d\n",
+		counts["current"], counts["journaled"], counts["planned"], counts[journal.ActionUpdated], counts[journal.ActionReplaced])
+	return err
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	if list {
+		for _, m := range migration.All() {
+			fmt.Printf("%s\tversion %d\t%s\n", m.Name, m.Version, m.Description)
+		}
+		return
+	}
+	mig, err := migration.Get(migrationName)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	renderer, err = publish.LoadBodyRenderer(bodyTemplate)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+
+	var jnl *journal.Journal
+	if !dryRun {
+		client, err = jinmaFlags.Client()
+		if err != nil {
+			glog.Fatalf("%+v", err)
+		}
+		limiter = util.NewRateLimiter(requestsPerSec)
+		defer limiter.Stop()
+		// Fail early on an invalid token.
+		if _, err := client.Me(ctx); err != nil {
+			glog.Fatalf("validating the Jinma token: %+v", err)
+		}
+
+		if journalfile == "" {
+			journalfile = infile + ".migration.journal"
+		}
+		jnl, err = journal.Open(journalfile)
+		if err != nil {
+			glog.Fatalf("%+v", err)
+		}
+	}
+
+	if err := migrate(ctx, mig, jnl); err != nil {
+		glog.Fatalf("%+v, rerun to resume", err)
+	}
+	// The journal refers to the lines of infile, which is stale after the migration.
+	if jnl != nil {
+		jnl.Close()
+		if err := os.Remove(journalfile); err != nil {
+			glog.Fatalf("%+v", err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -9,17 +10,33 @@ import (
 	"github.com/pkg/errors"
 
 	"housing"
+	"housing/gazetteer"
+	"housing/util"
 )
 
 var (
-	gcpAPIKey string
-	cachefile string
-	dirname   string
+	gcpAPIKeyFile string
+	gcpAPIKey     string
+	geocodePing   bool
+	cachefile     string
+	gazetteerfile string
+	dirname       string
+	retryPolicy   = util.DefaultRetryPolicy
+	budget        = housing.GeocodeBudget{CostPerCall: housing.DefaultCostPerCall}
 )
 
 func init() {
-	flag.StringVar(&gcpAPIKey, "gcpAPIKey", "", "GCP API Key for Google Maps Geocoding API")
+	flag.StringVar(&gcpAPIKeyFile, "gcpAPIKeyFile", "", "file containing the GCP API Key, which must not be readable by others")
+	flag.StringVar(&gcpAPIKey, "gcpAPIKey", "", "GCP API Key for Google Maps Geocoding API, prefer $GCP_API_KEY or gcpAPIKeyFile")
+	flag.BoolVar(&geocodePing, "geocodePing", true, "check the API Key with a geocoding API call at startup")
 	flag.StringVar(&cachefile, "cachefile", "", "cache file for prefetched geocoding results")
+	flag.StringVar(&gazetteerfile, "gazetteerfile", "", "GeoJSON file of 鄉鎮市區 boundaries for validating and falling back geocoding results, defaults to the gazetteer bundled by go generate housing/gazetteer")
+	flag.IntVar(&retryPolicy.MaxAttempts, "geocodeMaxAttempts", retryPolicy.MaxAttempts, "maximum number of attempts to geocode an address on retryable errors")
+	flag.DurationVar(&retryPolicy.InitialBackoff, "geocodeInitialBackoff", retryPolicy.InitialBackoff, "wait before the first geocoding retry, doubled on every retry")
+	flag.DurationVar(&retryPolicy.MaxBackoff, "geocodeMaxBackoff", retryPolicy.MaxBackoff, "maximum wait between geocoding retries")
+	flag.IntVar(&budget.MaxLiveCalls, "geocodeMaxCalls", 0, "maximum number of geocoding API calls, 0 for no limit")
+	flag.Float64Var(&budget.MaxCost, "geocodeMaxCost", 0, "maximum cost in USD of geocoding API calls, 0 for no limit")
+	flag.Float64Var(&budget.CostPerCall, "geocodeCostPerCall", budget.CostPerCall, "cost in USD of a geocoding API call")
 	flag.StringVar(&dirname, "dirname", "", "directory containing 實價登錄 files")
 }
 
@@ -53,14 +70,16 @@ func filterOut(fname string, rowID int, row []string) bool {
 	return false
 }
 
-func parse(fname string, rowID int, row []string, geocoder *housing.Geocoder) error {
+func parse(ctx context.Context, fname string, rowID int, row []string, geocoder *housing.Geocoder) error {
 	if filterOut(fname, rowID, row) {
 		return nil
 	}
 
-	ts, err := housing.ParseRow(row, geocoder)
+	ts, err := housing.ParseRowContext(ctx, housing.CountyCodeFromFilename(fname), row, geocoder)
 	if err != nil {
-		if _, ok := errors.Cause(err).(*housing.GeocodeNoResultsError); ok {
+		switch errors.Cause(err).(type) {
+		case *housing.GeocodeNoResultsError, *housing.GeocodeOutOfBoundsError:
+			glog.Infof("skipping %s:%d %v", fname, rowID, err)
 			return nil
 		}
 		return errors.Wrap(err, fmt.Sprintf("housing.ParseRow %s %d %+v", fname, rowID, row))
@@ -76,19 +95,53 @@ func parse(fname string, rowID int, row []string, geocoder *housing.Geocoder) er
 
 func main() {
 	flag.Parse()
+	defer glog.Flush()
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+
+	apiKey, err := util.LoadCredential(housing.APIKeyEnv, gcpAPIKeyFile, gcpAPIKey)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
 
 	// We use a large precision, since the cache already contains all attempted to geocoded all addresses.
 	var precisionMeters float64 = 999999
-	geocoder := housing.NewGeocoder(gcpAPIKey, precisionMeters)
+	geocoder := housing.NewGeocoder(apiKey, precisionMeters)
+	geocoder.RetryPolicy = retryPolicy
+	geocoder.Budget = budget
+	defer func() {
+		glog.Infof("%s", geocoder.Report())
+	}()
 	if cachefile != "" {
 		geocoder.PopulateCache(cachefile)
 	}
+	gz, err := gazetteer.LoadOrDefault(gazetteerfile)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	geocoder.Gazetteer = gz
+	if apiKey != "" && geocodePing {
+		if err := geocoder.Ping(ctx); err != nil {
+			glog.Fatalf("checking the GCP API Key: %+v", err)
+		}
+	}
 
+	lastFname, lastRowID := "", -1
 	rowFn := func(fname string, rowID int, row []string) error {
-		return parse(fname, rowID, row, geocoder)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := parse(ctx, fname, rowID, row, geocoder); err != nil {
+			return err
+		}
+		lastFname, lastRowID = fname, rowID
+		return nil
 	}
-	err := housing.ScanDir(dirname, rowFn)
+	err = housing.ScanDir(dirname, rowFn)
 	if err != nil {
 		glog.Errorf("%+v", err)
 	}
+	if ctx.Err() != nil {
+		glog.Infof("interrupted after %s:%d", lastFname, lastRowID)
+	}
 }
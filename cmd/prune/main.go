@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/transaction"
+	"housing/util"
+	"housing/util/jinma"
+)
+
+var (
+	jinmaFlags    *jinma.Flags
+	infile        string
+	publishedfile string
+	dryRun        bool
+	maxDeletions  int
+)
+
+func init() {
+	jinmaFlags = jinma.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&infile, "infile", "", "input file containing the current parsed transactions")
+	flag.StringVar(&publishedfile, "publishedfile", "", "dump of the published messages by cmd/get_msgs")
+	flag.BoolVar(&dryRun, "dryRun", true, "only print the messages that would be deleted")
+	flag.IntVar(&maxDeletions, "maxDeletions", 100, "refuse to delete anything if more than this many messages are stale")
+}
+
+// staleMsgs returns the published messages whose CustomIDs are no longer in the parsed transactions.
+func staleMsgs() ([]jinma.Msg, error) {
+	current := make(map[string]bool)
+	err := transaction.ScanFile(infile, func(line int, ts transaction.Transaction) error {
+		current[ts.A編號] = true
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction.ScanFile")
+	}
+	if len(current) == 0 {
+		return nil, fmt.Errorf("no transactions in %s", infile)
+	}
+
+	stale := []jinma.Msg{}
+	err = jinma.ScanDump(publishedfile, func(rowID int, msg jinma.Msg) error {
+		if msg.CustomID != "" && !current[msg.CustomID] {
+			stale = append(stale, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "jinma.ScanDump")
+	}
+	return stale, nil
+}
+
+func prune(ctx context.Context, client *jinma.Client) error {
+	stale, err := staleMsgs()
+	if err != nil {
+		return err
+	}
+	for _, msg := range stale {
+		fmt.Printf("%s\t%s\n", msg.ID, msg.CustomID)
+	}
+	glog.Infof("%d stale messages", len(stale))
+	if dryRun {
+		return nil
+	}
+	if len(stale) > maxDeletions {
+		return fmt.Errorf("%d stale messages exceed maxDeletions %d", len(stale), maxDeletions)
+	}
+
+	for i, msg := range stale {
+		if err := client.MsgDelete(ctx, msg.ID); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("client.MsgDelete %d %s %s", i, msg.ID, msg.CustomID))
+		}
+		glog.Infof("deleted %d msg.ID: %s, 編號: %s", i, msg.ID, msg.CustomID)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+	client, err := jinmaFlags.Client()
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+
+	if err := prune(ctx, client); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+}
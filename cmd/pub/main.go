@@ -1,107 +1,365 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
+	"housing/publish"
 	"housing/transaction"
+	"housing/util"
 	"housing/util/jinma"
+	"housing/util/journal"
 )
 
 var (
-	infile       string
-	infileOffset int
-	jinmaToken   string
-	randomSeed   int64
+	jinmaFlags      *jinma.Flags
+	infile          string
+	publishedfile   string
+	updatePublished bool
+	journalfile     string
+	numWorkers      int
+	requestsPerSec  float64
+	retryPolicy     = util.DefaultRetryPolicy
+	hashtags        string
+	priceBands      string
+	bodyTemplate    string
 )
 
 func init() {
+	jinmaFlags = jinma.RegisterFlags(flag.CommandLine)
 	flag.StringVar(&infile, "infile", "", "input file containing the parsed transactions")
-	flag.IntVar(&infileOffset, "infileOffset", 0, "line offset from which we should read from infile")
-	flag.StringVar(&jinmaToken, "jinmaToken", "", "Jinma user token")
-	flag.Int64Var(&randomSeed, "randomSeed", 0, "random seed")
+	flag.StringVar(&publishedfile, "publishedfile", "", "dump of the published messages by cmd/get_msgs, the published messages are scanned from Jinma if empty")
+	flag.BoolVar(&updatePublished, "updatePublished", false, "update the body, sortkey and hashtags of transactions that are already published instead of skipping them")
+	flag.StringVar(&journalfile, "journal", "", "checkpoint journal of the published rows, defaults to infile.pub.journal")
+	flag.IntVar(&numWorkers, "workers", 4, "number of concurrent requests to Jinma")
+	flag.Float64Var(&requestsPerSec, "rps", 10, "maximum requests per second to Jinma, 0 for no limit")
+	flag.IntVar(&retryPolicy.MaxAttempts, "maxAttempts", retryPolicy.MaxAttempts, "maximum number of attempts of requests to Jinma, creates are retried only if they did not reach Jinma")
+	flag.StringVar(&hashtags, "hashtags", strings.Join(publish.DefaultTagKinds, ","), "comma separated kinds of hashtags of the messages, among "+strings.Join(publish.DefaultTagKinds, ","))
+	flag.StringVar(&priceBands, "priceBands", publish.FormatPriceBands(publish.DefaultPriceBands), "comma separated bounds of the price band hashtags in 萬元")
+	flag.StringVar(&bodyTemplate, "bodyTemplate", "", "text/template file of the summaries in the message bodies, defaults to publish.DefaultBodyTemplate")
 }
 
-func create(inTs transaction.Transaction) (*jinma.Msg, error) {
-	// Make a copy of the transaction and remove the unneeded fields.
-	ts := inTs
-	// These fields are unneeded because they are contained in the jinma.Msg itself.
-	ts.A編號 = ""
-	ts.Lat = 0
-	ts.Lng = 0
+// publisher publishes transactions to Jinma.
+type publisher struct {
+	client   *jinma.Client
+	limiter  *util.RateLimiter
+	tagger   *publish.Tagger
+	renderer *publish.BodyRenderer
+	// retryPolicy is the policy of creates, which the client does not retry.
+	retryPolicy util.RetryPolicy
+	numWorkers  int
+	// publishedfile is a dump of the published messages, which are scanned from Jinma if it is empty.
+	publishedfile   string
+	updatePublished bool
+}
 
-	tsbody, err := json.Marshal(ts)
+// loadPublished returns the IDs of the published messages keyed by their CustomIDs.
+func (p *publisher) loadPublished(ctx context.Context) (map[string]string, error) {
+	msgs, err := jinma.LoadMsgs(ctx, p.client, p.publishedfile)
 	if err != nil {
-		return nil, errors.Wrap(err, "marshal")
+		return nil, errors.Wrap(err, "jinma.LoadMsgs")
+	}
+	published := make(map[string]string)
+	for _, msg := range msgs {
+		if msg.CustomID == "" {
+			continue
+		}
+		if id, ok := published[msg.CustomID]; ok {
+			glog.Warningf("duplicate messages %s %s of CustomID %s", id, msg.ID, msg.CustomID)
+			continue
+		}
+		published[msg.CustomID] = msg.ID
 	}
+	return published, nil
+}
+
+// publishedIndex maps the CustomIDs of published messages to their IDs.
+type publishedIndex struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func (p *publishedIndex) get(customID string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id, ok := p.ids[customID]
+	return id, ok
+}
 
-	// Use the transaction date as the sortkey.
-	// To avoid collided sortkeys, randomly a time interval.
-	skf64 := float64(ts.A交易年月日)
-	skf64 += float64(rand.Intn(24*60*60 - 1))
-	skf64 += rand.Float64()
+func (p *publishedIndex) set(customID, msgID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ids[customID] = msgID
+}
 
-	customID := inTs.A編號
-	if customID == "" {
-		return nil, errors.Wrap(err, fmt.Sprintf("empty customID for %+v", inTs))
+func (p *publisher) pubTransaction(ctx context.Context, ts transaction.Transaction, skf64 float64, published *publishedIndex) (string, string, error) {
+	// Transactions that are already published are skipped or updated,
+	// so that a failed run can simply be rerun.
+	if msgID, ok := published.get(ts.A編號); ok {
+		if !p.updatePublished {
+			return journal.ActionSkipped, msgID, nil
+		}
+		body, err := p.renderer.MsgBody(ts)
+		if err != nil {
+			return "", "", errors.Wrap(err, "renderer.MsgBody")
+		}
+		if err := p.limiter.Wait(ctx); err != nil {
+			return "", "", err
+		}
+		msg, err := publish.Update(ctx, p.client, msgID, body, skf64, p.tagger.Tags(ts))
+		if err != nil {
+			return "", "", errors.Wrap(err, "update")
+		}
+		return journal.ActionUpdated, msg.ID, nil
 	}
 
-	msg, err := jinma.MsgCreate(jinmaToken, string(tsbody), ts.Lat, ts.Lng, &skf64, customID)
+	body, err := p.renderer.MsgBody(ts)
+	if err != nil {
+		return "", "", errors.Wrap(err, "renderer.MsgBody")
+	}
+	if err := p.limiter.Wait(ctx); err != nil {
+		return "", "", err
+	}
+	msg, err := p.create(ctx, ts, body, skf64)
 	if err != nil {
-		return nil, errors.Wrap(err, "jinma.MsgCreate")
+		return "", "", errors.Wrap(err, "create")
+	}
+	published.set(ts.A編號, msg.ID)
+	return journal.ActionCreated, msg.ID, nil
+}
+
+// create publishes ts, retrying only the attempts that certainly did not reach Jinma.
+// MsgCreate is not idempotent and Jinma accepts duplicate CustomIDs,
+// so a row whose create failed otherwise is left to the next run, which skips it if the scan finds its message.
+func (p *publisher) create(ctx context.Context, ts transaction.Transaction, body []byte, skf64 float64) (*jinma.Msg, error) {
+	var err error
+	attempts := p.retryPolicy.Attempts()
+	for i := 0; i < attempts; i++ {
+		var msg *jinma.Msg
+		msg, err = publish.Create(ctx, p.client, ts, body, skf64, p.tagger.Tags(ts))
+		if err == nil {
+			return msg, nil
+		}
+		if !jinma.IsNotSent(err) || i == attempts-1 {
+			break
+		}
+		glog.Warningf("attempt %d of %s: %v", i, ts.A編號, err)
+		if err := p.retryPolicy.Sleep(ctx, i); err != nil {
+			return nil, err
+		}
+		if err := p.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
 	}
-	return msg, nil
+	return nil, err
+}
+
+type job struct {
+	line  int
+	ts    transaction.Transaction
+	skf64 float64
 }
 
-func pubFile(fname string) error {
+type result struct {
+	line     int
+	customID string
+	action   string
+	msgID    string
+	err      error
+}
+
+func (p *publisher) pubJob(ctx context.Context, j job, published *publishedIndex) result {
+	r := result{line: j.line, customID: j.ts.A編號}
+	r.action, r.msgID, r.err = p.pubTransaction(ctx, j.ts, j.skf64, published)
+	return r
+}
+
+// readJobs streams the transactions of fname that are not yet in jnl to jobs.
+// Sort keys are assigned to all transactions in file order, including those in jnl, so that they are the same in every run.
+func readJobs(ctx context.Context, fname string, jnl *journal.Journal, published *publishedIndex, jobs chan<- job) error {
 	f, err := os.Open(fname)
 	if err != nil {
 		return errors.Wrap(err, "open")
 	}
 	defer f.Close()
-	fbody, err := ioutil.ReadAll(f)
-	if err != nil {
-		return errors.Wrap(err, "readall")
-	}
-	lines := strings.Split(string(fbody), "\n")
-	for i, line := range lines {
-		// When resuming a previous run, update the offset here
-		// to continue from where we left.
-		if i < infileOffset {
-			continue
-		}
 
-		if line == "" {
+	keys := publish.NewSortKeys()
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for i := 0; scanner.Scan(); i++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
 			continue
 		}
 
 		ts := transaction.Transaction{}
-		if err := json.Unmarshal([]byte(line), &ts); err != nil {
-			return errors.Wrap(err, "unmarshal line")
+		if err := json.Unmarshal(line, &ts); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unmarshal line %d", i))
 		}
+		skf64 := keys.Key(ts)
 
-		msg, err := create(ts)
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("create error %d %+v", i, ts))
+		// Resume from where the previous run stopped.
+		if e, ok := jnl.Line(i); ok && e.CustomID == ts.A編號 {
+			if e.MsgID != "" {
+				published.set(ts.A編號, e.MsgID)
+			}
+			continue
 		}
-		glog.Infof("created row: %d, msg.ID: %s, ts: %+v", i, msg.ID, ts)
+		// Concurrent workers cannot tell whether a duplicate row is published yet.
+		if seen[ts.A編號] {
+			glog.Warningf("skipping duplicate row %d of %s", i, ts.A編號)
+			continue
+		}
+		seen[ts.A編號] = true
+
+		select {
+		case jobs <- job{line: i, ts: ts, skf64: skf64}:
+		case <-ctx.Done():
+			glog.Infof("interrupted before row %d", i)
+			return ctx.Err()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "scanner.Err")
 	}
 	return nil
 }
 
+type summary struct {
+	counts map[string]int
+	failed []result
+}
+
+func (s *summary) String() string {
+	sort.Slice(s.failed, func(i, j int) bool { return s.failed[i].line < s.failed[j].line })
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "created: %d, updated: %d, skipped: %d, failed: %d\n",
+		s.counts[journal.ActionCreated], s.counts[journal.ActionUpdated], s.counts[journal.ActionSkipped], len(s.failed))
+	for _, r := range s.failed {
+		fmt.Fprintf(&b, "failed row: %d, 編號: %s, err: %v\n", r.line, r.customID, r.err)
+	}
+	return b.String()
+}
+
+func (p *publisher) pubFile(ctx context.Context, fname string, jnl *journal.Journal) (*summary, error) {
+	ids, err := p.loadPublished(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "loadPublished")
+	}
+	glog.Infof("found %d published messages, %d rows in journal", len(ids), jnl.Len())
+	published := &publishedIndex{ids: ids}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan job)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < p.numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- p.pubJob(ctx, j, published)
+			}
+		}()
+	}
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(jobs)
+		readErr <- readJobs(ctx, fname, jnl, published, jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	s := &summary{counts: make(map[string]int)}
+	var jnlErr error
+	for r := range results {
+		if r.err != nil {
+			glog.Errorf("failed row: %d, 編號: %s, err: %+v", r.line, r.customID, r.err)
+			s.failed = append(s.failed, r)
+			continue
+		}
+		if jnlErr == nil {
+			jnlErr = jnl.Append(journal.Entry{Line: r.line, CustomID: r.customID, MsgID: r.msgID, Action: r.action})
+			if jnlErr != nil {
+				cancel()
+			}
+		}
+		s.counts[r.action]++
+		if r.action == journal.ActionSkipped {
+			glog.V(1).Infof("skipped row: %d, msg.ID: %s", r.line, r.msgID)
+			continue
+		}
+		glog.Infof("%s row: %d, 編號: %s, msg.ID: %s", r.action, r.line, r.customID, r.msgID)
+	}
+	if jnlErr != nil {
+		return s, errors.Wrap(jnlErr, "journal.Append")
+	}
+	return s, <-readErr
+}
+
 func main() {
 	flag.Parse()
-	rand.Seed(randomSeed)
+	defer glog.Flush()
+	client, err := jinmaFlags.Client(jinma.WithRetryPolicy(retryPolicy))
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	p := &publisher{
+		client:          client,
+		retryPolicy:     retryPolicy,
+		numWorkers:      numWorkers,
+		publishedfile:   publishedfile,
+		updatePublished: updatePublished,
+	}
+	p.tagger, err = publish.NewTagger(hashtags, priceBands)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	p.renderer, err = publish.LoadBodyRenderer(bodyTemplate)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	p.limiter = util.NewRateLimiter(requestsPerSec)
+	defer p.limiter.Stop()
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+
+	// Fail early on an invalid token.
+	me, err := p.client.Me(ctx)
+	if err != nil {
+		glog.Fatalf("validating the Jinma token: %+v", err)
+	}
+	glog.Infof("running as user %s of app %s", me.User.ID, me.App.ID)
+
+	if journalfile == "" {
+		journalfile = infile + ".pub.journal"
+	}
+	jnl, err := journal.Open(journalfile)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	defer jnl.Close()
 
-	if err := pubFile(infile); err != nil {
+	s, err := p.pubFile(ctx, infile, jnl)
+	if err != nil {
 		glog.Errorf("%+v", err)
 	}
+	if s != nil {
+		fmt.Print(s)
+	}
 }
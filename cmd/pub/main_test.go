@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"housing/publish"
+	"housing/transaction"
+	"housing/util"
+	"housing/util/jinma"
+	"housing/util/jinma/jinmatest"
+	"housing/util/journal"
+)
+
+const (
+	testToken = "test-token"
+	testApp   = "app"
+)
+
+// setup starts a fake Jinma server and returns it with a publisher to it.
+func setup(t *testing.T) (*jinmatest.Server, *publisher) {
+	s := jinmatest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddUser(testToken, jinma.User{ID: "user"}, jinma.App{ID: testApp})
+
+	retryPolicy := util.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+	p := &publisher{
+		client:      s.Client(testToken, jinma.WithRetryPolicy(retryPolicy)),
+		retryPolicy: retryPolicy,
+		numWorkers:  2,
+	}
+	var err error
+	if p.tagger, err = publish.NewTagger(strings.Join(publish.DefaultTagKinds, ","), publish.FormatPriceBands(publish.DefaultPriceBands)); err != nil {
+		t.Fatal(err)
+	}
+	if p.renderer, err = publish.NewBodyRenderer(publish.DefaultBodyTemplate); err != nil {
+		t.Fatal(err)
+	}
+	return s, p
+}
+
+// testTransaction returns the i-th transaction written by writeTransactions.
+func testTransaction(i int) transaction.Transaction {
+	return transaction.Transaction{
+		A編號:    fmt.Sprintf("RPTEST%04d", i),
+		A鄉鎮市區:  "信義區",
+		A交易年月日: 1497484800,
+		A總價元:   10000000 + i,
+		Lat:    25.03 + float64(i)/1000,
+		Lng:    121.56,
+	}
+}
+
+// writeTransactions writes n transactions to a file in dir and returns its name.
+func writeTransactions(t *testing.T, dir string, n int) string {
+	var b []byte
+	for i := 0; i < n; i++ {
+		line, err := json.Marshal(testTransaction(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(append(b, line...), '\n')
+	}
+	fname := filepath.Join(dir, "transactions.jsonl")
+	if err := ioutil.WriteFile(fname, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+// run publishes fname with p and the journal in dir, as a run of cmd/pub does.
+func run(t *testing.T, p *publisher, dir, fname string) *summary {
+	jnl, err := journal.Open(filepath.Join(dir, "pub.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jnl.Close()
+	s, err := p.pubFile(context.Background(), fname, jnl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// checkJournal checks that the journal in dir has the action of each of the n transactions written by writeTransactions.
+func checkJournal(t *testing.T, dir string, n int, action string) {
+	jnl, err := journal.Open(filepath.Join(dir, "pub.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jnl.Close()
+	for i := 0; i < n; i++ {
+		if e, ok := jnl.Line(i); !ok || e.Action != action || e.CustomID != fmt.Sprintf("RPTEST%04d", i) {
+			t.Errorf("journal of row %d is %+v, want %s", i, e, action)
+		}
+	}
+}
+
+// checkPublished checks that each of the n transactions written by writeTransactions has exactly one message,
+// at its location, with the sort key assigned in file order, the hashtags of tagger and a body of the transaction.
+func checkPublished(t *testing.T, s *jinmatest.Server, p *publisher, n int) {
+	msgs := make(map[string][]jinma.Msg)
+	for _, msg := range s.Msgs() {
+		msgs[msg.CustomID] = append(msgs[msg.CustomID], msg)
+	}
+	keys := publish.NewSortKeys()
+	for i := 0; i < n; i++ {
+		ts := testTransaction(i)
+		skf64 := keys.Key(ts)
+		got := msgs[ts.A編號]
+		if len(got) != 1 {
+			t.Errorf("%d messages of %s, want 1", len(got), ts.A編號)
+			continue
+		}
+		if got[0].Lat != ts.Lat || got[0].Lng != ts.Lng {
+			t.Errorf("message of %s at %f,%f, want %f,%f", ts.A編號, got[0].Lat, got[0].Lng, ts.Lat, ts.Lng)
+		}
+		if got[0].SKF64 != skf64 {
+			t.Errorf("sort key of %s is %f, want %f", ts.A編號, got[0].SKF64, skf64)
+		}
+		if want := p.tagger.Tags(ts); fmt.Sprint(got[0].Hashtags) != fmt.Sprint(want) {
+			t.Errorf("hashtags of %s are %v, want %v", ts.A編號, got[0].Hashtags, want)
+		}
+		if decoded, err := publish.DecodeBody(got[0]); err != nil || decoded != ts {
+			t.Errorf("body of %s decodes to %+v, %v", ts.A編號, decoded, err)
+		}
+	}
+	if len(msgs) != n {
+		t.Errorf("messages of %d CustomIDs, want %d", len(msgs), n)
+	}
+}
+
+func TestPubFile(t *testing.T) {
+	s, p := setup(t)
+	dir := t.TempDir()
+	fname := writeTransactions(t, dir, 5)
+	sum := run(t, p, dir, fname)
+	if sum.counts[journal.ActionCreated] != 5 || len(sum.failed) != 0 {
+		t.Fatalf("got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+	checkJournal(t, dir, 5, journal.ActionCreated)
+
+	// A rerun finds every row in the journal.
+	sum = run(t, p, dir, fname)
+	if len(sum.counts) != 0 || len(sum.failed) != 0 {
+		t.Fatalf("rerun got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+}
+
+func TestPubFileServerError(t *testing.T) {
+	s, p := setup(t)
+	dir := t.TempDir()
+	fname := writeTransactions(t, dir, 5)
+	// A create that fails with a server error may have been processed, so it is not retried.
+	s.FailNext("/MsgCreate", 1, 503)
+	sum := run(t, p, dir, fname)
+	if sum.counts[journal.ActionCreated] != 4 || len(sum.failed) != 1 {
+		t.Fatalf("got %v", sum)
+	}
+
+	sum = run(t, p, dir, fname)
+	if sum.counts[journal.ActionCreated] != 1 || len(sum.failed) != 0 {
+		t.Fatalf("rerun got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+}
+
+func TestPubFileDroppedCreateResponse(t *testing.T) {
+	s, p := setup(t)
+	dir := t.TempDir()
+	fname := writeTransactions(t, dir, 5)
+	// The message is created, but its response is lost.
+	s.DropCreateResponses(1)
+	sum := run(t, p, dir, fname)
+	if sum.counts[journal.ActionCreated] != 4 || len(sum.failed) != 1 {
+		t.Fatalf("got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+
+	// The rerun finds the message in the scan instead of creating a duplicate.
+	sum = run(t, p, dir, fname)
+	if sum.counts[journal.ActionSkipped] != 1 || sum.counts[journal.ActionCreated] != 0 || len(sum.failed) != 0 {
+		t.Fatalf("rerun got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+}
+
+func TestPubFileRateLimited(t *testing.T) {
+	s, p := setup(t)
+	dir := t.TempDir()
+	fname := writeTransactions(t, dir, 5)
+	s.FailNext("/MsgCreate", 2, 429)
+	sum := run(t, p, dir, fname)
+	if sum.counts[journal.ActionCreated] != 5 || len(sum.failed) != 0 {
+		t.Fatalf("got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+}
+
+func TestPubFileMaxAttemptsZero(t *testing.T) {
+	s, p := setup(t)
+	dir := t.TempDir()
+	fname := writeTransactions(t, dir, 2)
+	p.retryPolicy.MaxAttempts = 0
+	sum := run(t, p, dir, fname)
+	if sum.counts[journal.ActionCreated] != 2 || len(sum.failed) != 0 {
+		t.Fatalf("got %v", sum)
+	}
+	checkPublished(t, s, p, 2)
+}
+
+func TestPubFileResume(t *testing.T) {
+	s, p := setup(t)
+	dir := t.TempDir()
+	fname := writeTransactions(t, dir, 5)
+	// Errors that are not retryable fail the row.
+	s.FailNext("/MsgCreate", 1, 400)
+	sum := run(t, p, dir, fname)
+	if sum.counts[journal.ActionCreated] != 4 || len(sum.failed) != 1 {
+		t.Fatalf("got %v", sum)
+	}
+
+	// The rerun creates only the messages that are not published yet.
+	sum = run(t, p, dir, fname)
+	if sum.counts[journal.ActionCreated] != 1 || len(sum.failed) != 0 {
+		t.Fatalf("rerun got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+	checkJournal(t, dir, 5, journal.ActionCreated)
+}
+
+func TestPubFileNewJournal(t *testing.T) {
+	s, p := setup(t)
+	fname := writeTransactions(t, t.TempDir(), 5)
+	run(t, p, t.TempDir(), fname)
+
+	// A run with a new journal finds the published messages in the scan.
+	dir := t.TempDir()
+	sum := run(t, p, dir, fname)
+	if sum.counts[journal.ActionSkipped] != 5 || len(sum.failed) != 0 {
+		t.Fatalf("got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+	checkJournal(t, dir, 5, journal.ActionSkipped)
+}
+
+func TestPubFilePublishedfile(t *testing.T) {
+	s, p := setup(t)
+	dir := t.TempDir()
+	fname := writeTransactions(t, dir, 5)
+	run(t, p, t.TempDir(), fname)
+
+	var b []byte
+	for _, msg := range s.Msgs() {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(append(b, line...), '\n')
+	}
+	p.publishedfile = filepath.Join(dir, "msgs.jsonl")
+	if err := ioutil.WriteFile(p.publishedfile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Scans of Jinma fail, so the published messages can only be found in publishedfile.
+	s.FailNext("/MsgsByAppUser", 1, 400)
+	sum := run(t, p, t.TempDir(), fname)
+	if sum.counts[journal.ActionSkipped] != 5 || len(sum.failed) != 0 {
+		t.Fatalf("got %v", sum)
+	}
+	checkPublished(t, s, p, 5)
+}
+
+func TestPubFileUpdatePublished(t *testing.T) {
+	s, p := setup(t)
+	fname := writeTransactions(t, t.TempDir(), 3)
+	run(t, p, t.TempDir(), fname)
+	for _, msg := range s.Msgs() {
+		if _, err := p.client.MsgUpdate(context.Background(), msg.ID, []byte("{}"), nil, []string{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Updates are idempotent, so server errors are retried.
+	p.updatePublished = true
+	s.FailNext("/MsgUpdate", 2, 503)
+	sum := run(t, p, t.TempDir(), fname)
+	if sum.counts[journal.ActionUpdated] != 3 || len(sum.failed) != 0 {
+		t.Fatalf("got %v", sum)
+	}
+	checkPublished(t, s, p, 3)
+	for _, msg := range s.Msgs() {
+		if msg.Body == "{}" {
+			t.Errorf("message %s of %s is not updated", msg.ID, msg.CustomID)
+		}
+	}
+}
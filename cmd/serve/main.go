@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"housing"
+	"housing/geo"
+	"housing/store"
+	"housing/transaction"
+	"housing/util"
+)
+
+var (
+	dbfile       string
+	addr         string
+	defaultLimit int
+	maxLimit     int
+)
+
+func init() {
+	flag.StringVar(&dbfile, "db", "", "SQLite file of the store written by cmd/load")
+	flag.StringVar(&addr, "addr", "localhost:8080", "address to listen on")
+	flag.IntVar(&defaultLimit, "defaultLimit", 100, "number of transactions per page if limit is not given")
+	flag.IntVar(&maxLimit, "maxLimit", 1000, "maximum number of transactions per page")
+}
+
+// Formats of the responses.
+const (
+	formatJSON    = "json"
+	formatCSV     = "csv"
+	formatGeoJSON = "geojson"
+)
+
+// dateLayout is the layout of the from and to parameters.
+const dateLayout = "2006-01-02"
+
+// server serves the transactions of st.
+type server struct {
+	st           *store.Store
+	defaultLimit int
+	maxLimit     int
+}
+
+// request is a parsed query of /transactions.
+type request struct {
+	query  store.Query
+	format string
+}
+
+// parseRange parses the parameters minKey and maxKey.
+func parseRange(v url.Values, minKey, maxKey string) (store.Range, error) {
+	r := store.Range{}
+	for _, b := range []struct {
+		key   string
+		bound **float64
+	}{{minKey, &r.Min}, {maxKey, &r.Max}} {
+		s := v.Get(b.key)
+		if s == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid %s %s", b.key, s)
+		}
+		*b.bound = &f
+	}
+	return r, nil
+}
+
+// parseDate parses the date parameter key into Unix time.
+func parseDate(v url.Values, key string) (*float64, error) {
+	s := v.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+	tm, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %s, want %s", key, s, dateLayout)
+	}
+	f := float64(tm.Unix())
+	return &f, nil
+}
+
+// parseBBox parses minLng,minLat,maxLng,maxLat as in GeoJSON.
+func parseBBox(s string) (*geo.Rect, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid bbox %s, want minLng,minLat,maxLng,maxLat", s)
+	}
+	c := make([]float64, 4)
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bbox %s", s)
+		}
+		c[i] = f
+	}
+	return &geo.Rect{Min: geo.Point{Lng: c[0], Lat: c[1]}, Max: geo.Point{Lng: c[2], Lat: c[3]}}, nil
+}
+
+func parseInt(v url.Values, key string, def int) (int, error) {
+	s := v.Get(key)
+	if s == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid %s %s", key, s)
+	}
+	return i, nil
+}
+
+func (s *server) parseRequest(v url.Values) (request, error) {
+	req := request{format: formatJSON}
+	if f := v.Get("format"); f != "" {
+		req.format = f
+	}
+	switch req.format {
+	case formatJSON, formatCSV, formatGeoJSON:
+	default:
+		return req, fmt.Errorf("invalid format %s", req.format)
+	}
+
+	q := &req.query
+	// county is either a county code such as A or a name such as 臺北市.
+	if county := v.Get("county"); county != "" {
+		q.CountyCode = county
+		if housing.CountyName(county) == "" {
+			q.CountyCode = housing.CountyCode(county)
+		}
+		if q.CountyCode == "" {
+			return req, fmt.Errorf("unknown county %s", county)
+		}
+	}
+	q.District = v.Get("district")
+	q.BuildingType = v.Get("buildingType")
+
+	var err error
+	if q.Date.Min, err = parseDate(v, "from"); err != nil {
+		return req, err
+	}
+	if q.Date.Max, err = parseDate(v, "to"); err != nil {
+		return req, err
+	}
+	ranges := []struct {
+		r      *store.Range
+		prefix string
+	}{
+		{&q.Rooms, "Rooms"},
+		{&q.Price, "Price"},
+		{&q.UnitPrice, "UnitPrice"},
+		{&q.Area, "Area"},
+	}
+	for _, r := range ranges {
+		if *r.r, err = parseRange(v, "min"+r.prefix, "max"+r.prefix); err != nil {
+			return req, err
+		}
+	}
+	if bbox := v.Get("bbox"); bbox != "" {
+		if q.BBox, err = parseBBox(bbox); err != nil {
+			return req, err
+		}
+	}
+
+	q.Sort = v.Get("sort")
+	if _, ok := store.SortKeys[strings.TrimPrefix(q.Sort, "-")]; q.Sort != "" && !ok {
+		return req, fmt.Errorf("invalid sort %s", q.Sort)
+	}
+	if q.Limit, err = parseInt(v, "limit", s.defaultLimit); err != nil {
+		return req, err
+	}
+	if q.Limit == 0 || q.Limit > s.maxLimit {
+		return req, fmt.Errorf("limit must be between 1 and %d", s.maxLimit)
+	}
+	if q.Offset, err = parseInt(v, "offset", 0); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, a ...interface{}) {
+	writeJSON(w, status, struct {
+		Message string
+	}{Message: fmt.Sprintf(format, a...)})
+}
+
+// location returns the point of ts, or nil if it has no location.
+func location(ts transaction.Transaction) *geo.Point {
+	if store.LocationQualityOf(ts) == store.LocationMissing {
+		return nil
+	}
+	return &geo.Point{Lat: ts.Lat, Lng: ts.Lng}
+}
+
+func (s *server) transactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+	req, err := s.parseRequest(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	ctx := r.Context()
+	total, err := s.st.Count(ctx, req.query)
+	if err != nil {
+		glog.Errorf("%+v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	switch req.format {
+	case formatJSON:
+		tss := []transaction.Transaction{}
+		err = s.st.Find(ctx, req.query, func(ts transaction.Transaction) error {
+			tss = append(tss, ts)
+			return nil
+		})
+		if err != nil {
+			glog.Errorf("%+v", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Total        int
+			Limit        int
+			Offset       int
+			Transactions []transaction.Transaction
+		}{Total: total, Limit: req.query.Limit, Offset: req.query.Offset, Transactions: tss})
+
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(transaction.Keys())
+		err = s.st.Find(ctx, req.query, func(ts transaction.Transaction) error {
+			return cw.Write(ts.Record())
+		})
+		cw.Flush()
+
+	case formatGeoJSON:
+		w.Header().Set("Content-Type", "application/geo+json")
+		fw := geo.NewFeatureCollectionWriter(w)
+		err = s.st.Find(ctx, req.query, func(ts transaction.Transaction) error {
+			return fw.WritePoint(location(ts), ts)
+		})
+		if err == nil {
+			err = fw.Close()
+		}
+	}
+	// The status is already sent when streaming, so errors can only be logged.
+	if err != nil {
+		glog.Errorf("%s: %+v", r.URL, err)
+	}
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+	if dbfile == "" {
+		glog.Fatalf("no db")
+	}
+	st, err := store.OpenReadOnly(dbfile)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	defer st.Close()
+
+	s := &server{st: st, defaultLimit: defaultLimit, maxLimit: maxLimit}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/transactions", s.transactions)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	glog.Infof("listening on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		glog.Fatalf("%+v", err)
+	}
+}
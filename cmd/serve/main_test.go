@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"housing/store"
+	"housing/transaction"
+)
+
+// setup serves five transactions of 臺北市 and one of 新北市, whose prices increase with their 編號.
+func setup(t *testing.T) *httptest.Server {
+	st, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	ctx := context.Background()
+	b, err := st.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		ts := transaction.Transaction{
+			A編號:        fmt.Sprintf("RPTEST%04d", i),
+			A鄉鎮市區:      "大安區",
+			A建物型態:      "住宅大樓(11層含以上有電梯)",
+			A交易年月日:     1497484800,
+			A總價元:       10000000 + i,
+			Lat:        25.03,
+			Lng:        121.54,
+			CountyCode: "A",
+		}
+		if i == 5 {
+			ts.A鄉鎮市區 = "板橋區"
+			ts.CountyCode = "F"
+		}
+		if _, err := b.Upsert(ctx, ts); err != nil {
+			b.Rollback()
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{st: st, defaultLimit: 2, maxLimit: 3}
+	srv := httptest.NewServer(http.HandlerFunc(s.transactions))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// page is the JSON response of /transactions.
+type page struct {
+	Total        int
+	Limit        int
+	Offset       int
+	Transactions []transaction.Transaction
+}
+
+func get(t *testing.T, srv *httptest.Server, query string) *http.Response {
+	resp, err := http.Get(srv.URL + "/transactions?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestTransactions(t *testing.T) {
+	srv := setup(t)
+	tests := []struct {
+		query  string
+		total  string
+		offset int
+		ids    string
+	}{
+		{"", "6", 0, "[RPTEST0000 RPTEST0001]"},
+		{"county=A", "5", 0, "[RPTEST0000 RPTEST0001]"},
+		{"county=新北市", "1", 0, "[RPTEST0005]"},
+		{"county=A&minPrice=10000002&maxPrice=10000003", "2", 0, "[RPTEST0002 RPTEST0003]"},
+		{"district=板橋區", "1", 0, "[RPTEST0005]"},
+		{"sort=-price", "6", 0, "[RPTEST0005 RPTEST0004]"},
+		{"county=A&sort=-price&limit=3", "5", 0, "[RPTEST0004 RPTEST0003 RPTEST0002]"},
+		{"county=A&sort=-price&limit=3&offset=3", "5", 3, "[RPTEST0001 RPTEST0000]"},
+		{"offset=10", "6", 10, "[]"},
+		{"from=2017-06-16", "0", 0, "[]"},
+	}
+	for _, tt := range tests {
+		resp := get(t, srv, tt.query)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", tt.query, resp.StatusCode)
+			continue
+		}
+		if got := resp.Header.Get("X-Total-Count"); got != tt.total {
+			t.Errorf("%s: X-Total-Count %s, want %s", tt.query, got, tt.total)
+		}
+		p := page{}
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, ts := range p.Transactions {
+			ids = append(ids, ts.A編號)
+		}
+		if fmt.Sprint(ids) != tt.ids || fmt.Sprint(p.Total) != tt.total || p.Offset != tt.offset {
+			t.Errorf("%s: page %d %d %v, want %s %d %s", tt.query, p.Total, p.Offset, ids, tt.total, tt.offset, tt.ids)
+		}
+	}
+}
+
+func TestTransactionsBadRequest(t *testing.T) {
+	srv := setup(t)
+	for _, query := range []string{
+		// Only the keys of store.SortKeys are sorted by, which keeps other columns out of the SQL.
+		"sort=A總價元",
+		"sort=-price;DROP TABLE transactions",
+		"limit=0",
+		"limit=4",
+		"limit=-1",
+		"offset=x",
+		"county=火星",
+		"minPrice=x",
+		"from=20170616",
+		"bbox=121,25,122",
+		"format=xml",
+	} {
+		if resp := get(t, srv, query); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	resp, err := http.Post(srv.URL+"/transactions", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestTransactionsFormats(t *testing.T) {
+	srv := setup(t)
+
+	resp := get(t, srv, "format=csv&county=A&limit=3")
+	if got := resp.Header.Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("csv Content-Type %s", got)
+	}
+	if got := resp.Header.Get("X-Total-Count"); got != "5" {
+		t.Errorf("csv X-Total-Count %s, want 5", got)
+	}
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || fmt.Sprint(records[0]) != fmt.Sprint(transaction.Keys()) {
+		t.Errorf("csv records %v, want the header and 3 transactions", records)
+	}
+
+	resp = get(t, srv, "format=geojson&county=新北市")
+	if got := resp.Header.Get("Content-Type"); got != "application/geo+json" {
+		t.Errorf("geojson Content-Type %s", got)
+	}
+	fc := struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Coordinates []float64
+			}
+		}
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&fc); err != nil {
+		t.Fatal(err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 1 || fmt.Sprint(fc.Features[0].Geometry.Coordinates) != "[121.54 25.03]" {
+		t.Errorf("geojson %+v", fc)
+	}
+}
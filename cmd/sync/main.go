@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/geo"
+	"housing/publish"
+	"housing/transaction"
+	"housing/util"
+	"housing/util/jinma"
+)
+
+var (
+	jinmaFlags     *jinma.Flags
+	infile         string
+	publishedfile  string
+	dryRun         bool
+	deleteRemoved  bool
+	confirmAbove   int
+	yes            bool
+	toleranceMeter float64
+	requestsPerSec float64
+	hashtags       string
+	priceBands     string
+	bodyTemplate   string
+
+	tagger   *publish.Tagger
+	renderer *publish.BodyRenderer
+)
+
+func init() {
+	jinmaFlags = jinma.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&infile, "infile", "", "input file containing the parsed transactions")
+	flag.StringVar(&publishedfile, "publishedfile", "", "dump of the published messages by cmd/get_msgs, the published messages are scanned from Jinma if empty")
+	flag.BoolVar(&dryRun, "dryRun", false, "only print the plan")
+	flag.BoolVar(&deleteRemoved, "delete", false, "delete the published messages whose transactions are not in infile, such as retracted transactions")
+	flag.IntVar(&confirmAbove, "confirmAbove", 100, "ask for confirmation if the plan has more changes than this")
+	flag.BoolVar(&yes, "yes", false, "apply the plan without asking for confirmation")
+	flag.Float64Var(&toleranceMeter, "toleranceMeters", 1, "distance beyond which a published location is considered changed")
+	flag.Float64Var(&requestsPerSec, "rps", 10, "maximum requests per second to Jinma, 0 for no limit")
+	flag.StringVar(&hashtags, "hashtags", strings.Join(publish.DefaultTagKinds, ","), "comma separated kinds of hashtags of the messages, among "+strings.Join(publish.DefaultTagKinds, ","))
+	flag.StringVar(&priceBands, "priceBands", publish.FormatPriceBands(publish.DefaultPriceBands), "comma separated bounds of the price band hashtags in 萬元")
+	flag.StringVar(&bodyTemplate, "bodyTemplate", "", "text/template file of the summaries in the message bodies, defaults to publish.DefaultBodyTemplate")
+}
+
+// Kinds of changes in a plan.
+const (
+	opCreate = "create"
+	// opUpdate updates the body, the sort key or the hashtags of a message.
+	opUpdate = "update"
+	// opRecreate replaces a message whose location changed, since MsgUpdate cannot move messages.
+	opRecreate = "recreate"
+	// opDelete deletes duplicate messages, and with deleteRemoved the messages of transactions that are not in infile.
+	opDelete = "delete"
+)
+
+type change struct {
+	op      string
+	ts      transaction.Transaction
+	skf64   float64
+	msg     jinma.Msg
+	reasons []string
+}
+
+func (c change) String() string {
+	customID := c.ts.A編號
+	if customID == "" {
+		customID = c.msg.CustomID
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s", c.op, customID, c.msg.ID, strings.Join(c.reasons, ","))
+}
+
+// sameTags reports whether a and b have the same hashtags, in any order.
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diff compares a transaction to its published message.
+func diff(ts transaction.Transaction, skf64 float64, msg jinma.Msg) (string, []string, error) {
+	reasons := []string{}
+	op := ""
+
+	// Bodies do not contain locations, which are compared below.
+	body, err := renderer.MsgBody(ts)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "renderer.MsgBody")
+	}
+	if msg.Body != string(body) {
+		op = opUpdate
+		reasons = append(reasons, "body")
+	}
+	if msg.SKF64 != skf64 {
+		op = opUpdate
+		reasons = append(reasons, "sortkey")
+	}
+	if !sameTags(tagger.Tags(ts), msg.Hashtags) {
+		op = opUpdate
+		reasons = append(reasons, "hashtags")
+	}
+	d := geo.Distance(geo.Point{Lat: ts.Lat, Lng: ts.Lng}, geo.Point{Lat: msg.Lat, Lng: msg.Lng})
+	if d > toleranceMeter {
+		op = opRecreate
+		reasons = append(reasons, fmt.Sprintf("location %.0fm", d))
+	}
+	return op, reasons, nil
+}
+
+// plan returns the changes that reconcile the published messages with infile,
+// and the number of published messages not in infile that are kept because deleteRemoved is false.
+func plan(ctx context.Context, client *jinma.Client) ([]change, int, error) {
+	current := make(map[string]transaction.Transaction)
+	order := []string{}
+	err := transaction.ScanFile(infile, func(line int, ts transaction.Transaction) error {
+		if ts.A編號 == "" {
+			return fmt.Errorf("empty 編號 at line %d", line)
+		}
+		if _, ok := current[ts.A編號]; !ok {
+			order = append(order, ts.A編號)
+		}
+		current[ts.A編號] = ts
+		return nil
+	})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "transaction.ScanFile")
+	}
+	if len(current) == 0 {
+		return nil, 0, fmt.Errorf("no transactions in %s", infile)
+	}
+	keys := publish.NewSortKeys()
+	skf64s := make(map[string]float64, len(order))
+	for _, customID := range order {
+		skf64s[customID] = keys.Key(current[customID])
+	}
+
+	msgs, err := jinma.LoadMsgs(ctx, client, publishedfile)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "jinma.LoadMsgs")
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+
+	byCustomID := make(map[string][]jinma.Msg)
+	msgOrder := []string{}
+	for _, msg := range msgs {
+		if msg.CustomID == "" {
+			continue
+		}
+		if _, ok := byCustomID[msg.CustomID]; !ok {
+			msgOrder = append(msgOrder, msg.CustomID)
+		}
+		byCustomID[msg.CustomID] = append(byCustomID[msg.CustomID], msg)
+	}
+
+	changes := []change{}
+	kept := 0
+	for _, customID := range msgOrder {
+		published := byCustomID[customID]
+		ts, ok := current[customID]
+		if !ok {
+			if !deleteRemoved {
+				kept += len(published)
+				continue
+			}
+			for _, msg := range published {
+				changes = append(changes, change{op: opDelete, msg: msg, reasons: []string{"removed"}})
+			}
+			continue
+		}
+
+		// Of duplicate messages, such as those left by a recreation that stopped before deleting the old message,
+		// the first that is up to date is kept.
+		keep := -1
+		ops := make([]change, len(published))
+		for i, msg := range published {
+			op, reasons, err := diff(ts, skf64s[customID], msg)
+			if err != nil {
+				return nil, 0, err
+			}
+			ops[i] = change{op: op, ts: ts, skf64: skf64s[customID], msg: msg, reasons: reasons}
+			if op == "" && keep < 0 {
+				keep = i
+			}
+		}
+		if keep < 0 {
+			keep = 0
+		}
+		for i, msg := range published {
+			if i != keep {
+				changes = append(changes, change{op: opDelete, msg: msg, reasons: []string{"duplicate"}})
+			}
+		}
+		if ops[keep].op != "" {
+			changes = append(changes, ops[keep])
+		}
+	}
+	for _, customID := range order {
+		if _, ok := byCustomID[customID]; !ok {
+			changes = append(changes, change{op: opCreate, ts: current[customID], skf64: skf64s[customID], reasons: []string{"new"}})
+		}
+	}
+	return changes, kept, nil
+}
+
+func apply(ctx context.Context, client *jinma.Client, limiter *util.RateLimiter, c change) error {
+	var body []byte
+	if c.op != opDelete {
+		var err error
+		if body, err = renderer.MsgBody(c.ts); err != nil {
+			return errors.Wrap(err, "renderer.MsgBody")
+		}
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		return err
+	}
+	switch c.op {
+	case opCreate:
+		_, err := publish.Create(ctx, client, c.ts, body, c.skf64, tagger.Tags(c.ts))
+		return err
+	case opUpdate:
+		_, err := publish.Update(ctx, client, c.msg.ID, body, c.skf64, tagger.Tags(c.ts))
+		return err
+	case opRecreate:
+		// The old message is deleted only after the new one is created, so that a failure never loses the message.
+		// The duplicate left by a failed deletion is deleted by the next run.
+		if _, err := publish.Create(ctx, client, c.ts, body, c.skf64, tagger.Tags(c.ts)); err != nil {
+			return err
+		}
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		return errors.Wrap(client.MsgDelete(ctx, c.msg.ID), "client.MsgDelete")
+	case opDelete:
+		return client.MsgDelete(ctx, c.msg.ID)
+	}
+	return fmt.Errorf("unknown op %s", c.op)
+}
+
+func confirm(n int) bool {
+	fmt.Fprintf(os.Stderr, "apply %d changes? [y/N] ", n)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func reconcile(ctx context.Context, client *jinma.Client) error {
+	changes, kept, err := plan(ctx, client)
+	if err != nil {
+		return errors.Wrap(err, "plan")
+	}
+	if kept > 0 {
+		fmt.Fprintf(os.Stderr, "kept %d published messages that are not in %s, rerun with -delete to delete them\n", kept, infile)
+	}
+
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.op]++
+		fmt.Println(c)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d, %s: %d, %s: %d, %s: %d\n",
+		opCreate, counts[opCreate], opUpdate, counts[opUpdate], opRecreate, counts[opRecreate], opDelete, counts[opDelete])
+	if dryRun || len(changes) == 0 {
+		return nil
+	}
+	if len(changes) > confirmAbove && !yes && !confirm(len(changes)) {
+		return fmt.Errorf("aborted")
+	}
+
+	limiter := util.NewRateLimiter(requestsPerSec)
+	defer limiter.Stop()
+	for i, c := range changes {
+		if err := apply(ctx, client, limiter, c); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("apply %d %s", i, c))
+		}
+		glog.Infof("applied %d %s", i, c)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+	client, err := jinmaFlags.Client()
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	tagger, err = publish.NewTagger(hashtags, priceBands)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	renderer, err = publish.LoadBodyRenderer(bodyTemplate)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+
+	if err := reconcile(ctx, client); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSameTags(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want bool
+	}{
+		{nil, nil, true},
+		{nil, []string{}, true},
+		{[]string{"信義區", "公寓"}, []string{"信義區", "公寓"}, true},
+		// Jinma may return hashtags in another order.
+		{[]string{"信義區", "公寓"}, []string{"公寓", "信義區"}, true},
+		{[]string{"公寓", "信義區"}, []string{"信義區", "公寓"}, true},
+		{[]string{"信義區", "公寓"}, []string{"信義區"}, false},
+		{[]string{"信義區", "公寓"}, []string{"信義區", "大樓"}, false},
+		{[]string{"信義區", "信義區"}, []string{"信義區", "公寓"}, false},
+	}
+	for _, tt := range tests {
+		a := append([]string{}, tt.a...)
+		if got := sameTags(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameTags(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if fmt.Sprint(tt.a) != fmt.Sprint(a) {
+			t.Errorf("sameTags reordered %v to %v", a, tt.a)
+		}
+	}
+}
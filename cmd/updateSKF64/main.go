@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/migration"
+	"housing/publish"
+	"housing/transaction"
+	"housing/util"
+	"housing/util/jinma"
+	"housing/util/journal"
+)
+
+var (
+	jinmaFlags   *jinma.Flags
+	infile       string
+	journalfile  string
+	bodyTemplate string
+)
+
+func init() {
+	jinmaFlags = jinma.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&infile, "infile", "", "input file containing messages")
+	flag.StringVar(&journalfile, "journal", "", "checkpoint journal of the updated messages, defaults to infile.updateSKF64.journal")
+	flag.StringVar(&bodyTemplate, "bodyTemplate", "", "text/template file of the summaries in the rewritten message bodies, defaults to publish.DefaultBodyTemplate")
+}
+
+// sortKeyMigration assigns the sort keys, and its version marks the bodies of messages with such keys.
+const sortKeyMigration = "sortkey"
+
+// handleMsg updates the sort key of msg, and its body too if body is not nil.
+func handleMsg(ctx context.Context, client *jinma.Client, rowID int, msg jinma.Msg, body []byte, skf64 float64) error {
+	updatedMsg, err := client.MsgUpdate(ctx, msg.ID, body, &skf64, nil)
+	if err != nil {
+		return errors.Wrap(err, "client.MsgUpdate")
+	}
+
+	glog.Infof("%d %+v", rowID, updatedMsg)
+	return nil
+}
+
+// scanMsgs sets the sort keys of the messages in fname as the sortkey migration does,
+// and rewrites the bodies older than the version of the migration, so that they tell that their keys are derived from 編號.
+func scanMsgs(ctx context.Context, client *jinma.Client, renderer *publish.BodyRenderer, fname string, jnl *journal.Journal) error {
+	mig, err := migration.Get(sortKeyMigration)
+	if err != nil {
+		return errors.Wrap(err, "migration.Get")
+	}
+	glog.Infof("%d rows in journal", jnl.Len())
+	// Sort keys are assigned to all messages in dump order, including those in jnl, so that they are the same in every run.
+	fn := mig.New()
+	return jinma.ScanDump(fname, func(i int, msg jinma.Msg) error {
+		if err := ctx.Err(); err != nil {
+			glog.Infof("interrupted before row %d", i)
+			return err
+		}
+		ts, err := publish.DecodeBody(msg)
+		if err != nil {
+			return errors.Wrap(err, "publish.DecodeBody")
+		}
+		m := &migration.Msg{Orig: msg, Transaction: ts, SKF64: msg.SKF64}
+		if err := fn(m); err != nil {
+			return errors.Wrap(err, mig.Name)
+		}
+		// Resume from where the previous run stopped.
+		if e, ok := jnl.Line(i); ok && e.MsgID == msg.ID {
+			return nil
+		}
+		current := transaction.BodyVersion(msg.Body) >= mig.Version
+		if current && msg.SKF64 == m.SKF64 {
+			return nil
+		}
+
+		var body []byte
+		if !current {
+			body, err = renderer.VersionedMsgBody(ts, mig.Version)
+			if err != nil {
+				return errors.Wrap(err, "renderer.VersionedMsgBody")
+			}
+		}
+		if err := handleMsg(ctx, client, i, msg, body, m.SKF64); err != nil {
+			return errors.Wrap(err, "handleMsg")
+		}
+		e := journal.Entry{Line: i, CustomID: msg.CustomID, MsgID: msg.ID, Action: journal.ActionUpdated}
+		if err := jnl.Append(e); err != nil {
+			return errors.Wrap(err, "journal.Append")
+		}
+		return nil
+	})
+}
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	client, err := jinmaFlags.Client()
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	renderer, err := publish.LoadBodyRenderer(bodyTemplate)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+
+	// Fail early on an invalid token.
+	me, err := client.Me(ctx)
+	if err != nil {
+		glog.Fatalf("validating the Jinma token: %+v", err)
+	}
+	glog.Infof("running as user %s of app %s", me.User.ID, me.App.ID)
+
+	if journalfile == "" {
+		journalfile = infile + ".updateSKF64.journal"
+	}
+	jnl, err := journal.Open(journalfile)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	defer jnl.Close()
+
+	if err := scanMsgs(ctx, client, renderer, infile, jnl); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+}
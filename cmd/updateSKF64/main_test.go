@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"housing/publish"
+	"housing/transaction"
+	"housing/util"
+	"housing/util/jinma"
+	"housing/util/jinma/jinmatest"
+	"housing/util/journal"
+)
+
+const (
+	testToken = "test-token"
+	testDate  = 1497484800
+)
+
+// setup starts a fake Jinma server with n messages of the same day, with version 1 bodies and sort keys that are not derived from their 編號,
+// and returns the server, a client of it and a dump of the messages.
+func setup(t *testing.T, n int) (*jinmatest.Server, *jinma.Client, string) {
+	s := jinmatest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddUser(testToken, jinma.User{ID: "user"}, jinma.App{ID: "app"})
+	client := s.Client(testToken, jinma.WithRetryPolicy(util.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}))
+
+	renderer := newRenderer(t)
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		ts := transaction.Transaction{A編號: fmt.Sprintf("RPTEST%04d", i), A鄉鎮市區: "信義區", A交易年月日: testDate}
+		body, err := renderer.VersionedMsgBody(ts, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		skf64 := float64(i)
+		if _, err := client.MsgCreate(ctx, string(body), 25, 121, &skf64, nil, ts.A編號); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, client, dump(t, s)
+}
+
+// dump writes the messages of s to a new dump and returns its name.
+func dump(t *testing.T, s *jinmatest.Server) string {
+	var b []byte
+	for _, msg := range s.Msgs() {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(append(b, line...), '\n')
+	}
+	fname := filepath.Join(t.TempDir(), "msgs.jsonl")
+	if err := ioutil.WriteFile(fname, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func newRenderer(t *testing.T) *publish.BodyRenderer {
+	renderer, err := publish.NewBodyRenderer(publish.DefaultBodyTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return renderer
+}
+
+// run updates the sort keys of the messages in fname with client and the journal of fname, as a run of cmd/updateSKF64 does.
+func run(t *testing.T, client *jinma.Client, fname string) error {
+	jnl, err := journal.Open(fname + ".journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jnl.Close()
+	return scanMsgs(context.Background(), client, newRenderer(t), fname, jnl)
+}
+
+// checkSortKeys checks that the messages of s have the sort keys assigned in the order of the dump fname,
+// and bodies of the same transactions at version 2.
+func checkSortKeys(t *testing.T, s *jinmatest.Server, fname string) {
+	want := make(map[string]float64)
+	wantTs := make(map[string]transaction.Transaction)
+	keys := publish.NewSortKeys()
+	err := jinma.ScanDump(fname, func(i int, msg jinma.Msg) error {
+		ts, err := publish.DecodeBody(msg)
+		if err != nil {
+			return err
+		}
+		want[msg.ID] = keys.Key(ts)
+		wantTs[msg.ID] = ts
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := s.Msgs()
+	if len(msgs) != len(want) {
+		t.Errorf("%d messages, want %d", len(msgs), len(want))
+	}
+	for _, msg := range msgs {
+		if msg.SKF64 != want[msg.ID] {
+			t.Errorf("sort key of %s is %f, want %f", msg.ID, msg.SKF64, want[msg.ID])
+		}
+		if ts, err := publish.DecodeBody(msg); err != nil || ts != wantTs[msg.ID] || transaction.BodyVersion(msg.Body) != 2 {
+			t.Errorf("body of %s is %q, decoded to %+v, %v", msg.ID, msg.Body, ts, err)
+		}
+	}
+}
+
+func TestScanMsgs(t *testing.T) {
+	s, client, fname := setup(t, 10)
+	if err := run(t, client, fname); err != nil {
+		t.Fatal(err)
+	}
+	checkSortKeys(t, s, fname)
+}
+
+func TestScanMsgsServerError(t *testing.T) {
+	s, client, fname := setup(t, 10)
+	// Updates are idempotent, so server errors are retried.
+	s.FailNext("/MsgUpdate", 2, 503)
+	if err := run(t, client, fname); err != nil {
+		t.Fatal(err)
+	}
+	checkSortKeys(t, s, fname)
+}
+
+func TestScanMsgsResume(t *testing.T) {
+	s, client, fname := setup(t, 10)
+	// Errors that are not retryable stop the run.
+	s.FailNext("/MsgUpdate", 1, 400)
+	if err := run(t, client, fname); err == nil {
+		t.Fatal("no error")
+	}
+	if err := run(t, client, fname); err != nil {
+		t.Fatal(err)
+	}
+	checkSortKeys(t, s, fname)
+
+	// Messages in the journal are not updated again.
+	msgs := s.Msgs()
+	skf64 := 0.0
+	if _, err := client.MsgUpdate(context.Background(), msgs[0].ID, nil, &skf64, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, client, fname); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Msgs()[0].SKF64; got != skf64 {
+		t.Errorf("sort key of %s in the journal is updated to %f", msgs[0].ID, got)
+	}
+}
+
+func TestScanMsgsVersion2(t *testing.T) {
+	s, client, fname := setup(t, 10)
+	if err := run(t, client, fname); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bodies at version 2 are not rewritten, so a body that differs from the rendered one is kept.
+	ctx := context.Background()
+	bodies := make(map[string]string)
+	for i, msg := range s.Msgs() {
+		body := msg.Body + " "
+		var skf64 *float64
+		if i < 3 {
+			skf64 = new(float64)
+		}
+		if _, err := client.MsgUpdate(ctx, msg.ID, []byte(body), skf64, nil); err != nil {
+			t.Fatal(err)
+		}
+		bodies[msg.ID] = body
+	}
+	fname = dump(t, s)
+	if err := run(t, client, fname); err != nil {
+		t.Fatal(err)
+	}
+	checkSortKeys(t, s, fname)
+	for _, msg := range s.Msgs() {
+		if msg.Body != bodies[msg.ID] {
+			t.Errorf("body of %s at version 2 is rewritten", msg.ID)
+		}
+	}
+}
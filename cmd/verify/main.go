@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/geo"
+	"housing/publish"
+	"housing/transaction"
+	"housing/util"
+	"housing/util/jinma"
+)
+
+var (
+	jinmaFlags     *jinma.Flags
+	infile         string
+	publishedfile  string
+	reportfile     string
+	toleranceMeter float64
+)
+
+func init() {
+	jinmaFlags = jinma.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&infile, "infile", "", "input file containing the parsed transactions")
+	flag.StringVar(&publishedfile, "publishedfile", "", "dump of the published messages by cmd/get_msgs, the published messages are scanned from Jinma if empty")
+	flag.StringVar(&reportfile, "reportfile", "", "output file of the mismatches, defaults to stdout")
+	flag.Float64Var(&toleranceMeter, "toleranceMeters", 1, "distance beyond which a published location is a mismatch")
+}
+
+// Kinds of mismatches.
+const (
+	// kindMissing is a transaction without a published message.
+	kindMissing = "missing"
+	// kindExtra is a message whose CustomID is not in the parsed transactions.
+	kindExtra = "extra"
+	// kindDuplicate is a second message of the same CustomID.
+	kindDuplicate = "duplicate"
+	kindLocation  = "location"
+	kindBody      = "body"
+	kindSortKey   = "sortkey"
+)
+
+// mismatch is a line of the report.
+type mismatch struct {
+	Kind     string
+	CustomID string `json:",omitempty"`
+	MsgID    string `json:",omitempty"`
+	// Line is the line of the transaction in infile, or -1 for extra messages.
+	Line   int
+	Detail string `json:",omitempty"`
+}
+
+type source struct {
+	line int
+	ts   transaction.Transaction
+}
+
+// fields returns the body fields of ts, without the fields that are in jinma.Msg itself and CountyCode.
+func fields(ts transaction.Transaction) (map[string]interface{}, error) {
+	ts.A編號 = ""
+	ts.Lat = 0
+	ts.Lng = 0
+	ts.CountyCode = ""
+	b, err := json.Marshal(ts)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal")
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	return m, nil
+}
+
+// diffFields returns the names of the body fields that differ between a and b.
+func diffFields(a, b transaction.Transaction) ([]string, error) {
+	fa, err := fields(a)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for k, v := range fa {
+		if !reflect.DeepEqual(v, fb[k]) {
+			names = append(names, k)
+		}
+	}
+	for k := range fb {
+		if _, ok := fa[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func date(t float64) string {
+	return time.Unix(int64(t), 0).UTC().Format("2006-01-02")
+}
+
+// check compares a published message to its transaction.
+func check(src source, msg jinma.Msg) ([]mismatch, error) {
+	mismatches := []mismatch{}
+	add := func(kind, detail string) {
+		mismatches = append(mismatches, mismatch{Kind: kind, CustomID: msg.CustomID, MsgID: msg.ID, Line: src.line, Detail: detail})
+	}
+	ts := src.ts
+
+	d := geo.Distance(geo.Point{Lat: ts.Lat, Lng: ts.Lng}, geo.Point{Lat: msg.Lat, Lng: msg.Lng})
+	if msg.Lat == 0 && msg.Lng == 0 {
+		add(kindLocation, fmt.Sprintf("published at 0,0 instead of %f,%f", ts.Lat, ts.Lng))
+	} else if d > toleranceMeter {
+		add(kindLocation, fmt.Sprintf("published at %f,%f, %.0fm from %f,%f", msg.Lat, msg.Lng, d, ts.Lat, ts.Lng))
+	}
+
+	published, err := publish.DecodeBody(msg)
+	if err != nil {
+		add(kindBody, fmt.Sprintf("undecodable body: %v", err))
+	} else {
+		names, err := diffFields(published, ts)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("diffFields %s", msg.ID))
+		}
+		if len(names) > 0 {
+			add(kindBody, strings.Join(names, ","))
+		}
+	}
+
+	if !publish.SortKeyMatches(ts, msg.SKF64) {
+		add(kindSortKey, fmt.Sprintf("sortkey %f on %s instead of %s", msg.SKF64, date(msg.SKF64), date(float64(ts.A交易年月日))))
+	}
+	return mismatches, nil
+}
+
+func verify(ctx context.Context, client *jinma.Client) ([]mismatch, error) {
+	current := make(map[string]source)
+	order := []string{}
+	err := transaction.ScanFile(infile, func(line int, ts transaction.Transaction) error {
+		if ts.A編號 == "" {
+			return fmt.Errorf("empty 編號 at line %d", line)
+		}
+		if _, ok := current[ts.A編號]; !ok {
+			order = append(order, ts.A編號)
+		}
+		current[ts.A編號] = source{line: line, ts: ts}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction.ScanFile")
+	}
+
+	msgs, err := jinma.LoadMsgs(ctx, client, publishedfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "jinma.LoadMsgs")
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+
+	mismatches := []mismatch{}
+	published := make(map[string]bool)
+	for _, msg := range msgs {
+		src, ok := current[msg.CustomID]
+		if !ok {
+			mismatches = append(mismatches, mismatch{Kind: kindExtra, CustomID: msg.CustomID, MsgID: msg.ID, Line: -1})
+			continue
+		}
+		if published[msg.CustomID] {
+			mismatches = append(mismatches, mismatch{Kind: kindDuplicate, CustomID: msg.CustomID, MsgID: msg.ID, Line: src.line})
+			continue
+		}
+		published[msg.CustomID] = true
+
+		m, err := check(src, msg)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, m...)
+	}
+	for _, customID := range order {
+		if !published[customID] {
+			mismatches = append(mismatches, mismatch{Kind: kindMissing, CustomID: customID, Line: current[customID].line})
+		}
+	}
+	glog.Infof("verified %d messages against %d transactions", len(msgs), len(current))
+	return mismatches, nil
+}
+
+func writeReport(mismatches []mismatch) error {
+	out := os.Stdout
+	if reportfile != "" {
+		f, err := os.Create(reportfile)
+		if err != nil {
+			return errors.Wrap(err, "os.Create")
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, m := range mismatches {
+		if err := enc.Encode(m); err != nil {
+			return errors.Wrap(err, "Encode")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	ctx, cancel := util.SignalContext()
+	defer cancel()
+	client, err := jinmaFlags.Client()
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+
+	mismatches, err := verify(ctx, client)
+	if err != nil {
+		glog.Fatalf("%+v", err)
+	}
+	if err := writeReport(mismatches); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, m := range mismatches {
+		counts[m.Kind]++
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d, %s: %d, %s: %d, %s: %d, %s: %d, %s: %d\n",
+		kindMissing, counts[kindMissing], kindExtra, counts[kindExtra], kindDuplicate, counts[kindDuplicate],
+		kindLocation, counts[kindLocation], kindBody, counts[kindBody], kindSortKey, counts[kindSortKey])
+	if len(mismatches) > 0 {
+		glog.Flush()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,52 @@
+package gazetteer
+
+import (
+	"compress/gzip"
+	"embed"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"housing/geo"
+)
+
+//go:generate go run gen.go -src $TOWN_MOI -out data/towns.geojson.gz
+
+// bundledFile is the gazetteer generated by gen.go from the MOI 鄉鎮市區界線 dataset.
+const bundledFile = "data/towns.geojson.gz"
+
+//go:embed data
+var bundled embed.FS
+
+// ErrNotBundled is returned by Default if the binary was built without generating the gazetteer.
+// The gazetteer is not committed, so it is returned unless go generate was run before building.
+var ErrNotBundled = errors.New("no bundled gazetteer, run go generate housing/gazetteer with $TOWN_MOI set to the 鄉鎮市區界線 shapefile, or pass a GeoJSON file of the dataset")
+
+// Default returns the gazetteer bundled in the binary.
+func Default() (*Gazetteer, error) {
+	f, err := bundled.Open(bundledFile)
+	if os.IsNotExist(err) {
+		return nil, ErrNotBundled
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip.NewReader")
+	}
+	features, err := geo.ReadFeatures(zr)
+	if err != nil {
+		return nil, errors.Wrap(err, "geo.ReadFeatures")
+	}
+	return New(features)
+}
+
+// LoadOrDefault reads the gazetteer in fname, or returns the bundled one if fname is empty.
+func LoadOrDefault(fname string) (*Gazetteer, error) {
+	if fname == "" {
+		return Default()
+	}
+	return Load(fname)
+}
@@ -0,0 +1,126 @@
+package gazetteer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"housing/geo"
+)
+
+// Property keys used by the MOI 鄉鎮市區界線 dataset, available at
+// https://data.gov.tw/dataset/7441 and converted to GeoJSON with
+// ogr2ogr -f GeoJSON -t_srs EPSG:4326 towns.geojson TOWN_MOI_*.shp
+const (
+	countyNameKey = "COUNTYNAME"
+	townNameKey   = "TOWNNAME"
+	townCodeKey   = "TOWNCODE"
+)
+
+// countyAliases maps the county names used in 實價登錄 to their current names.
+var countyAliases = map[string]string{
+	"桃園縣": "桃園市",
+}
+
+func normalize(name string) string {
+	name = strings.TrimSpace(name)
+	name = strings.Replace(name, "台", "臺", -1)
+	if alias, ok := countyAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// A Town is a 鄉鎮市區.
+type Town struct {
+	County   string
+	Name     string
+	Code     string
+	Centroid geo.Point
+	Boundary geo.MultiPolygon
+
+	bound geo.Rect
+}
+
+func (t *Town) Contains(p geo.Point) bool {
+	return t.bound.Contains(p) && t.Boundary.Contains(p)
+}
+
+type Gazetteer struct {
+	towns  []*Town
+	byName map[string][]*Town
+	bound  geo.Rect
+}
+
+func New(features []geo.Feature) (*Gazetteer, error) {
+	g := Gazetteer{byName: make(map[string][]*Town)}
+	for i := range features {
+		f := &features[i]
+		t := &Town{
+			County:   normalize(f.StringProperty(countyNameKey)),
+			Name:     normalize(f.StringProperty(townNameKey)),
+			Code:     f.StringProperty(townCodeKey),
+			Centroid: f.Geometry.Centroid(),
+			Boundary: f.Geometry,
+			bound:    f.Geometry.Bound(),
+		}
+		if t.Name == "" {
+			return nil, fmt.Errorf("feature %d has no %s", i, townNameKey)
+		}
+		if len(g.towns) == 0 {
+			g.bound = t.bound
+		} else {
+			g.bound = g.bound.Union(t.bound)
+		}
+		g.towns = append(g.towns, t)
+		g.byName[t.Name] = append(g.byName[t.Name], t)
+	}
+	return &g, nil
+}
+
+// Load reads a gazetteer from a GeoJSON file of the MOI 鄉鎮市區界線 dataset.
+func Load(fname string) (*Gazetteer, error) {
+	features, err := geo.ReadFeaturesFile(fname)
+	if err != nil {
+		return nil, errors.Wrap(err, "geo.ReadFeaturesFile")
+	}
+	return New(features)
+}
+
+// Town returns the 鄉鎮市區 named town in county.
+// Since names such as 東區 and 中正區 exist in several counties,
+// a town is only returned without a county if its name is unambiguous.
+func (g *Gazetteer) Town(county, town string) *Town {
+	county = normalize(county)
+	candidates := g.byName[normalize(town)]
+	if county == "" {
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		return nil
+	}
+	for _, t := range candidates {
+		if t.County == county {
+			return t
+		}
+	}
+	return nil
+}
+
+// Locate returns the 鄉鎮市區 containing p, or nil if p is outside of Taiwan.
+func (g *Gazetteer) Locate(p geo.Point) *Town {
+	if !g.bound.Contains(p) {
+		return nil
+	}
+	for _, t := range g.towns {
+		if t.Contains(p) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (g *Gazetteer) InTaiwan(p geo.Point) bool {
+	return g.Locate(p) != nil
+}
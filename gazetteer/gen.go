@@ -0,0 +1,151 @@
+//go:build ignore
+
+// gen.go converts the MOI 鄉鎮市區界線 dataset into the gazetteer bundled by Default.
+// It keeps the properties used by the gazetteer and rounds coordinates to -decimals decimal places.
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/geo"
+)
+
+var (
+	src      string
+	out      string
+	decimals int
+)
+
+// keys are the properties of the features read by gazetteer.New.
+var keys = []string{"COUNTYNAME", "TOWNNAME", "TOWNCODE"}
+
+func init() {
+	flag.StringVar(&src, "src", "", "TOWN_MOI_*.shp of the 鄉鎮市區界線 dataset, or its GeoJSON conversion")
+	flag.StringVar(&out, "out", "", "gzipped GeoJSON output file")
+	flag.IntVar(&decimals, "decimals", 4, "decimal places of the coordinates, 4 is about 10 meters")
+}
+
+type feature struct {
+	Type       string            `json:"type"`
+	Properties map[string]string `json:"properties"`
+	Geometry   geometry          `json:"geometry"`
+}
+
+type geometry struct {
+	Type        string          `json:"type"`
+	Coordinates [][][][]float64 `json:"coordinates"`
+}
+
+// simplify rounds the coordinates of mp and drops the points that round to their predecessors,
+// and the rings that are left with less than 4 points.
+func simplify(mp geo.MultiPolygon) [][][][]float64 {
+	scale := math.Pow(10, float64(decimals))
+	round := func(f float64) float64 { return math.Round(f*scale) / scale }
+	polygons := [][][][]float64{}
+	for _, pg := range mp {
+		rings := [][][]float64{}
+		for i, ring := range pg {
+			coords := [][]float64{}
+			for _, p := range ring {
+				c := []float64{round(p.Lng), round(p.Lat)}
+				if n := len(coords); n > 0 && coords[n-1][0] == c[0] && coords[n-1][1] == c[1] {
+					continue
+				}
+				coords = append(coords, c)
+			}
+			if len(coords) < 4 {
+				// A polygon without its exterior ring is dropped.
+				if i == 0 {
+					break
+				}
+				continue
+			}
+			rings = append(rings, coords)
+		}
+		if len(rings) > 0 {
+			polygons = append(polygons, rings)
+		}
+	}
+	return polygons
+}
+
+func convert() error {
+	var features []geo.Feature
+	var err error
+	if strings.ToLower(filepath.Ext(src)) == ".shp" {
+		features, err = geo.ReadShapefile(src)
+	} else {
+		features, err = geo.ReadFeaturesFile(src)
+	}
+	if err != nil {
+		return errors.Wrap(err, src)
+	}
+
+	fc := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection"}
+	for _, f := range features {
+		props := make(map[string]string)
+		for _, key := range keys {
+			props[key] = f.StringProperty(key)
+		}
+		polygons := simplify(f.Geometry)
+		if len(polygons) == 0 {
+			glog.Warningf("dropping %s%s, which has no polygons after rounding", props["COUNTYNAME"], props["TOWNNAME"])
+			continue
+		}
+		fc.Features = append(fc.Features, feature{
+			Type:       "Feature",
+			Properties: props,
+			Geometry:   geometry{Type: "MultiPolygon", Coordinates: polygons},
+		})
+	}
+
+	// Written through a temporary file, so that out is either complete or unchanged.
+	tmp := out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "os.Create")
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	zw, err := gzip.NewWriterLevel(f, gzip.BestCompression)
+	if err != nil {
+		return errors.Wrap(err, "gzip.NewWriterLevel")
+	}
+	if err := json.NewEncoder(zw).Encode(fc); err != nil {
+		return errors.Wrap(err, "json.Encode")
+	}
+	if err := zw.Close(); err != nil {
+		return errors.Wrap(err, "gzip Close")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		return errors.Wrap(err, "os.Rename")
+	}
+	glog.Infof("wrote %d towns to %s", len(fc.Features), out)
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	defer glog.Flush()
+	if src == "" || out == "" {
+		glog.Fatalf("src and out are required, set $TOWN_MOI for go generate")
+	}
+	if err := convert(); err != nil {
+		glog.Fatalf("%+v", err)
+	}
+}
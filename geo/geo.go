@@ -0,0 +1,273 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	earthRadiusMeters = 6371000
+)
+
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+// Distance returns the great-circle distance in meters between two points.
+func Distance(a, b Point) float64 {
+	toRad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * toRad
+	dLng := (b.Lng - a.Lng) * toRad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.Lat*toRad)*math.Cos(b.Lat*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+}
+
+type Rect struct {
+	Min Point
+	Max Point
+}
+
+func (r Rect) Contains(p Point) bool {
+	return p.Lat >= r.Min.Lat && p.Lat <= r.Max.Lat && p.Lng >= r.Min.Lng && p.Lng <= r.Max.Lng
+}
+
+func (r Rect) Intersects(o Rect) bool {
+	return r.Min.Lat <= o.Max.Lat && o.Min.Lat <= r.Max.Lat && r.Min.Lng <= o.Max.Lng && o.Min.Lng <= r.Max.Lng
+}
+
+// Union returns the smallest Rect containing both r and o.
+func (r Rect) Union(o Rect) Rect {
+	return Rect{
+		Min: Point{Lat: math.Min(r.Min.Lat, o.Min.Lat), Lng: math.Min(r.Min.Lng, o.Min.Lng)},
+		Max: Point{Lat: math.Max(r.Max.Lat, o.Max.Lat), Lng: math.Max(r.Max.Lng, o.Max.Lng)},
+	}
+}
+
+// Polygon is a list of linear rings, where the first ring is the outer boundary
+// and the remaining rings are holes, as in GeoJSON.
+type Polygon [][]Point
+
+func ringContains(ring []Point, p Point) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			in = !in
+		}
+	}
+	return in
+}
+
+// ringArea returns the signed planar area of a ring in squared degrees.
+func ringArea(ring []Point) float64 {
+	a := 0.0
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a += ring[j].Lng*ring[i].Lat - ring[i].Lng*ring[j].Lat
+	}
+	return a / 2
+}
+
+func (pg Polygon) Contains(p Point) bool {
+	if len(pg) == 0 || !ringContains(pg[0], p) {
+		return false
+	}
+	for _, hole := range pg[1:] {
+		if ringContains(hole, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Area returns the planar area of the polygon in squared degrees.
+// It is only meant for comparing the sizes of polygons.
+func (pg Polygon) Area() float64 {
+	if len(pg) == 0 {
+		return 0
+	}
+	a := math.Abs(ringArea(pg[0]))
+	for _, hole := range pg[1:] {
+		a -= math.Abs(ringArea(hole))
+	}
+	return a
+}
+
+// Centroid returns the centroid of the outer ring of the polygon.
+func (pg Polygon) Centroid() Point {
+	if len(pg) == 0 || len(pg[0]) == 0 {
+		return Point{}
+	}
+	ring := pg[0]
+	var a, lat, lng float64
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		f := ring[j].Lng*ring[i].Lat - ring[i].Lng*ring[j].Lat
+		a += f
+		lng += (ring[j].Lng + ring[i].Lng) * f
+		lat += (ring[j].Lat + ring[i].Lat) * f
+	}
+	if a == 0 {
+		return ring[0]
+	}
+	return Point{Lat: lat / (3 * a), Lng: lng / (3 * a)}
+}
+
+type MultiPolygon []Polygon
+
+func (mp MultiPolygon) Contains(p Point) bool {
+	for _, pg := range mp {
+		if pg.Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Centroid returns the centroid of the largest polygon.
+// Using the largest polygon rather than the whole area keeps the centroid of
+// districts with offshore islands on land.
+func (mp MultiPolygon) Centroid() Point {
+	largest := -1
+	largestArea := -1.0
+	for i, pg := range mp {
+		if a := pg.Area(); a > largestArea {
+			largest = i
+			largestArea = a
+		}
+	}
+	if largest < 0 {
+		return Point{}
+	}
+	return mp[largest].Centroid()
+}
+
+func (mp MultiPolygon) Bound() Rect {
+	r := Rect{
+		Min: Point{Lat: math.Inf(1), Lng: math.Inf(1)},
+		Max: Point{Lat: math.Inf(-1), Lng: math.Inf(-1)},
+	}
+	for _, pg := range mp {
+		if len(pg) == 0 {
+			continue
+		}
+		for _, p := range pg[0] {
+			r.Min.Lat = math.Min(r.Min.Lat, p.Lat)
+			r.Min.Lng = math.Min(r.Min.Lng, p.Lng)
+			r.Max.Lat = math.Max(r.Max.Lat, p.Lat)
+			r.Max.Lng = math.Max(r.Max.Lng, p.Lng)
+		}
+	}
+	return r
+}
+
+type Feature struct {
+	Properties map[string]interface{}
+	Geometry   MultiPolygon
+}
+
+// StringProperty returns the property named key if it is a string, and "" otherwise.
+func (f *Feature) StringProperty(key string) string {
+	s, _ := f.Properties[key].(string)
+	return s
+}
+
+func toPoint(c []float64) (Point, error) {
+	if len(c) < 2 {
+		return Point{}, fmt.Errorf("invalid position %v", c)
+	}
+	return Point{Lat: c[1], Lng: c[0]}, nil
+}
+
+func toPolygon(rings [][][]float64) (Polygon, error) {
+	pg := make(Polygon, 0, len(rings))
+	for _, ring := range rings {
+		pts := make([]Point, 0, len(ring))
+		for _, c := range ring {
+			p, err := toPoint(c)
+			if err != nil {
+				return nil, err
+			}
+			pts = append(pts, p)
+		}
+		pg = append(pg, pts)
+	}
+	return pg, nil
+}
+
+func decodeGeometry(typ string, coordinates json.RawMessage) (MultiPolygon, error) {
+	switch typ {
+	case "Polygon":
+		rings := [][][]float64{}
+		if err := json.Unmarshal(coordinates, &rings); err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal Polygon")
+		}
+		pg, err := toPolygon(rings)
+		if err != nil {
+			return nil, err
+		}
+		return MultiPolygon{pg}, nil
+	case "MultiPolygon":
+		polygons := [][][][]float64{}
+		if err := json.Unmarshal(coordinates, &polygons); err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal MultiPolygon")
+		}
+		mp := make(MultiPolygon, 0, len(polygons))
+		for _, rings := range polygons {
+			pg, err := toPolygon(rings)
+			if err != nil {
+				return nil, err
+			}
+			mp = append(mp, pg)
+		}
+		return mp, nil
+	}
+	return nil, fmt.Errorf("unsupported geometry type %s", typ)
+}
+
+// ReadFeatures reads the Polygon and MultiPolygon features of a GeoJSON FeatureCollection.
+func ReadFeatures(r io.Reader) ([]Feature, error) {
+	fc := struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Properties map[string]interface{} `json:"properties"`
+			Geometry   *struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}{}
+	if err := json.NewDecoder(r).Decode(&fc); err != nil {
+		return nil, errors.Wrap(err, "json.Decode")
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("not a FeatureCollection: %s", fc.Type)
+	}
+
+	features := make([]Feature, 0, len(fc.Features))
+	for i, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+		mp, err := decodeGeometry(f.Geometry.Type, f.Geometry.Coordinates)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("feature %d", i))
+		}
+		features = append(features, Feature{Properties: f.Properties, Geometry: mp})
+	}
+	return features, nil
+}
+
+func ReadFeaturesFile(fname string) ([]Feature, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("os.Open %s", fname))
+	}
+	defer f.Close()
+	return ReadFeatures(f)
+}
@@ -0,0 +1,92 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Property is a property of a Feature.
+type Property struct {
+	Key   string
+	Value interface{}
+}
+
+// Properties are marshaled as a JSON object with the keys in order.
+type Properties []Property
+
+func (ps Properties) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, p := range ps {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(p.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "json.Marshal key")
+		}
+		value, err := json.Marshal(p.Value)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("json.Marshal %s", p.Key))
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// FeatureCollectionWriter streams a GeoJSON FeatureCollection of points,
+// so that large collections are never held in memory.
+type FeatureCollectionWriter struct {
+	w io.Writer
+	n int
+}
+
+func NewFeatureCollectionWriter(w io.Writer) *FeatureCollectionWriter {
+	return &FeatureCollectionWriter{w: w}
+}
+
+// WritePoint writes a Feature of the point p, or of a null geometry if p is nil, with properties marshaled as JSON.
+func (fw *FeatureCollectionWriter) WritePoint(p *Point, properties interface{}) error {
+	props, err := json.Marshal(properties)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal properties")
+	}
+	geometry := "null"
+	if p != nil {
+		// GeoJSON coordinates are longitude then latitude.
+		geometry = fmt.Sprintf(`{"type":"Point","coordinates":[%s,%s]}`, formatCoord(p.Lng), formatCoord(p.Lat))
+	}
+	prefix := ",\n"
+	if fw.n == 0 {
+		prefix = `{"type":"FeatureCollection","features":[` + "\n"
+	}
+	if _, err := fmt.Fprintf(fw.w, `%s{"type":"Feature","geometry":%s,"properties":%s}`, prefix, geometry, props); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	fw.n++
+	return nil
+}
+
+// Close ends the FeatureCollection. It does not close the underlying writer.
+func (fw *FeatureCollectionWriter) Close() error {
+	end := "\n]}\n"
+	if fw.n == 0 {
+		end = `{"type":"FeatureCollection","features":[]}` + "\n"
+	}
+	if _, err := io.WriteString(fw.w, end); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	return nil
+}
+
+func formatCoord(f float64) string {
+	b, _ := json.Marshal(f)
+	return string(b)
+}
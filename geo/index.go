@@ -0,0 +1,69 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+)
+
+type cell struct {
+	x int
+	y int
+}
+
+// Index is a grid index of features for point-in-polygon lookups.
+type Index struct {
+	features []Feature
+	bounds   []Rect
+	cellSize float64
+	cells    map[cell][]int
+}
+
+// NewIndex indexes features in a grid of cellSize degrees.
+// The cell size should be about the size of a typical feature.
+func NewIndex(features []Feature, cellSize float64) (*Index, error) {
+	if !(cellSize > 0) {
+		return nil, fmt.Errorf("cell size %g is not positive", cellSize)
+	}
+	idx := Index{
+		features: features,
+		bounds:   make([]Rect, len(features)),
+		cellSize: cellSize,
+		cells:    make(map[cell][]int),
+	}
+	for i, f := range features {
+		b := f.Geometry.Bound()
+		idx.bounds[i] = b
+		if math.IsInf(b.Min.Lat, 0) {
+			continue
+		}
+		lo, hi := idx.cellOf(b.Min), idx.cellOf(b.Max)
+		for x := lo.x; x <= hi.x; x++ {
+			for y := lo.y; y <= hi.y; y++ {
+				c := cell{x: x, y: y}
+				idx.cells[c] = append(idx.cells[c], i)
+			}
+		}
+	}
+	return &idx, nil
+}
+
+func (idx *Index) cellOf(p Point) cell {
+	return cell{
+		x: int(math.Floor(p.Lng / idx.cellSize)),
+		y: int(math.Floor(p.Lat / idx.cellSize)),
+	}
+}
+
+// Locate returns the first feature containing p, or nil if there is none.
+func (idx *Index) Locate(p Point) *Feature {
+	for _, i := range idx.cells[idx.cellOf(p)] {
+		if idx.bounds[i].Contains(p) && idx.features[i].Geometry.Contains(p) {
+			return &idx.features[i]
+		}
+	}
+	return nil
+}
+
+func (idx *Index) Len() int {
+	return len(idx.features)
+}
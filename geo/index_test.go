@@ -0,0 +1,57 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	features := []Feature{
+		// A feature spanning several cells, with a hole.
+		{Properties: map[string]interface{}{"CODE": "A"}, Geometry: MultiPolygon{{square(121, 25, 1), square(121.4, 25.4, 0.2)}}},
+		{Properties: map[string]interface{}{"CODE": "B"}, Geometry: MultiPolygon{{square(122, 25, 0.1)}}},
+		// A feature at negative coordinates, whose cells are rounded down.
+		{Properties: map[string]interface{}{"CODE": "C"}, Geometry: MultiPolygon{{square(-0.5, -0.5, 0.4)}}},
+		// Features without geometry are not indexed.
+		{Properties: map[string]interface{}{"CODE": "D"}},
+	}
+	idx, err := NewIndex(features, 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() != len(features) {
+		t.Errorf("Len %d, want %d", idx.Len(), len(features))
+	}
+
+	tests := []struct {
+		p    Point
+		want string
+	}{
+		{Point{25.05, 121.05}, "A"},
+		{Point{25.95, 121.95}, "A"},
+		{Point{25.5, 121.2}, "A"},
+		{Point{25.5, 121.5}, ""},
+		{Point{25.05, 122.05}, "B"},
+		{Point{25.05, 122.15}, ""},
+		{Point{-0.3, -0.3}, "C"},
+		{Point{0.3, 0.3}, ""},
+		{Point{0, 0}, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if f := idx.Locate(tt.p); f != nil {
+			got = f.StringProperty("CODE")
+		}
+		if got != tt.want {
+			t.Errorf("Locate(%v) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestNewIndexCellSize(t *testing.T) {
+	for _, cellSize := range []float64{0, -0.1, math.NaN()} {
+		if _, err := NewIndex(nil, cellSize); err == nil {
+			t.Errorf("no error of cell size %g", cellSize)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package geo
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KMLStyle is an icon style of placemarks written by KMLWriter.
+type KMLStyle struct {
+	ID string
+	// Color is the icon color in the aabbggrr hex format of KML.
+	Color string
+}
+
+// KMLWriter streams a KML document of points.
+type KMLWriter struct {
+	w   io.Writer
+	err error
+}
+
+// NewKMLWriter writes the header of a KML document named name with styles.
+func NewKMLWriter(w io.Writer, name string, styles ...KMLStyle) *KMLWriter {
+	kw := &KMLWriter{w: w}
+	kw.printf("%s<kml xmlns=\"http://www.opengis.net/kml/2.2\">\n<Document>\n<name>%s</name>\n", xml.Header, escape(name))
+	for _, s := range styles {
+		kw.printf("<Style id=\"%s\"><IconStyle><color>%s</color></IconStyle></Style>\n", escape(s.ID), escape(s.Color))
+	}
+	return kw
+}
+
+// printf writes to kw.w unless a previous write failed.
+func (kw *KMLWriter) printf(format string, a ...interface{}) {
+	if kw.err != nil {
+		return
+	}
+	if _, err := fmt.Fprintf(kw.w, format, a...); err != nil {
+		kw.err = errors.Wrap(err, "Write")
+	}
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// formatValue formats property values as KML text.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// WritePlacemark writes a Placemark of the point p, or without a geometry if p is nil,
+// with properties as ExtendedData. styleID is the ID of a KMLStyle, or empty for the default style.
+func (kw *KMLWriter) WritePlacemark(name string, p *Point, styleID string, properties Properties) error {
+	kw.printf("<Placemark>\n<name>%s</name>\n", escape(name))
+	if styleID != "" {
+		kw.printf("<styleUrl>#%s</styleUrl>\n", escape(styleID))
+	}
+	kw.printf("<ExtendedData>\n")
+	for _, prop := range properties {
+		kw.printf("<Data name=\"%s\"><value>%s</value></Data>\n", escape(prop.Key), escape(formatValue(prop.Value)))
+	}
+	kw.printf("</ExtendedData>\n")
+	if p != nil {
+		kw.printf("<Point><coordinates>%s,%s</coordinates></Point>\n", formatCoord(p.Lng), formatCoord(p.Lat))
+	}
+	kw.printf("</Placemark>\n")
+	return kw.err
+}
+
+// Close ends the document. It does not close the underlying writer.
+func (kw *KMLWriter) Close() error {
+	kw.printf("</Document>\n</kml>\n")
+	return kw.err
+}
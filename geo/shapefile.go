@@ -0,0 +1,227 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+const (
+	shpFileCode     = 9994
+	shpHeaderLen    = 100
+	shpNull         = 0
+	shpPolygon      = 5
+	shpPolygonZ     = 15
+	shpPolygonM     = 25
+	dbfHeaderEnd    = 0x0D
+	dbfFieldDescLen = 32
+)
+
+// ReadShapefile reads the polygons of fname.shp and their attributes in fname.dbf.
+// The coordinates must be longitudes and latitudes, such as the TWD97經緯度 datasets of MOI.
+// Attributes are read as strings, decoded as Big5 if the .cpg file says so, and as UTF-8 otherwise.
+func ReadShapefile(fname string) ([]Feature, error) {
+	base := strings.TrimSuffix(fname, ".shp")
+	shp, err := ioutil.ReadFile(base + ".shp")
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadFile shp")
+	}
+	geoms, err := parseShp(shp)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("parseShp %s.shp", base))
+	}
+
+	dbf, err := ioutil.ReadFile(base + ".dbf")
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadFile dbf")
+	}
+	big5 := false
+	if cpg, err := ioutil.ReadFile(base + ".cpg"); err == nil {
+		switch strings.ToUpper(strings.TrimSpace(string(cpg))) {
+		case "BIG5", "950", "CP950":
+			big5 = true
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "ioutil.ReadFile cpg")
+	}
+	attrs, err := parseDbf(dbf, big5)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("parseDbf %s.dbf", base))
+	}
+	if len(attrs) != len(geoms) {
+		return nil, fmt.Errorf("%d records in dbf not equal to %d shapes", len(attrs), len(geoms))
+	}
+
+	features := make([]Feature, 0, len(geoms))
+	for i, g := range geoms {
+		if g == nil {
+			continue
+		}
+		features = append(features, Feature{Properties: attrs[i], Geometry: g})
+	}
+	return features, nil
+}
+
+func parseShp(b []byte) ([]MultiPolygon, error) {
+	if len(b) < shpHeaderLen {
+		return nil, fmt.Errorf("short header")
+	}
+	if code := binary.BigEndian.Uint32(b[0:4]); code != shpFileCode {
+		return nil, fmt.Errorf("invalid file code %d", code)
+	}
+	geoms := []MultiPolygon{}
+	for off := shpHeaderLen; off+8 <= len(b); {
+		contentLen := int(binary.BigEndian.Uint32(b[off+4:off+8])) * 2
+		off += 8
+		if off+contentLen > len(b) {
+			return nil, fmt.Errorf("record %d overflows file", len(geoms)+1)
+		}
+		g, err := parseShpRecord(b[off : off+contentLen])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("record %d", len(geoms)+1))
+		}
+		geoms = append(geoms, g)
+		off += contentLen
+	}
+	return geoms, nil
+}
+
+func parseShpRecord(b []byte) (MultiPolygon, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("short record")
+	}
+	le := binary.LittleEndian
+	switch typ := le.Uint32(b[0:4]); typ {
+	case shpNull:
+		return nil, nil
+	case shpPolygon, shpPolygonZ, shpPolygonM:
+	default:
+		return nil, fmt.Errorf("unsupported shape type %d", typ)
+	}
+	if len(b) < 44 {
+		return nil, fmt.Errorf("short polygon")
+	}
+	numParts := int(le.Uint32(b[36:40]))
+	numPoints := int(le.Uint32(b[40:44]))
+	partsOff := 44
+	pointsOff := partsOff + 4*numParts
+	if pointsOff+16*numPoints > len(b) {
+		return nil, fmt.Errorf("polygon overflows record")
+	}
+
+	rings := make([][]Point, 0, numParts)
+	for i := 0; i < numParts; i++ {
+		start := int(le.Uint32(b[partsOff+4*i:]))
+		end := numPoints
+		if i+1 < numParts {
+			end = int(le.Uint32(b[partsOff+4*(i+1):]))
+		}
+		if start > end || end > numPoints {
+			return nil, fmt.Errorf("invalid part %d", i)
+		}
+		ring := make([]Point, 0, end-start)
+		for j := start; j < end; j++ {
+			o := pointsOff + 16*j
+			x := math.Float64frombits(le.Uint64(b[o:]))
+			y := math.Float64frombits(le.Uint64(b[o+8:]))
+			if math.Abs(x) > 180 || math.Abs(y) > 90 {
+				return nil, fmt.Errorf("coordinate %f,%f is not a longitude and latitude", x, y)
+			}
+			ring = append(ring, Point{Lat: y, Lng: x})
+		}
+		rings = append(rings, ring)
+	}
+	return groupRings(rings), nil
+}
+
+// groupRings groups the rings of a shapefile polygon into polygons.
+// Shapefiles order outer rings clockwise and holes counterclockwise.
+func groupRings(rings [][]Point) MultiPolygon {
+	mp := MultiPolygon{}
+	holes := [][]Point{}
+	for _, ring := range rings {
+		if ringArea(ring) <= 0 {
+			mp = append(mp, Polygon{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	for _, hole := range holes {
+		if len(hole) == 0 {
+			continue
+		}
+		for i := range mp {
+			if ringContains(mp[i][0], hole[0]) {
+				mp[i] = append(mp[i], hole)
+				break
+			}
+		}
+	}
+	return mp
+}
+
+type dbfField struct {
+	name   string
+	length int
+}
+
+func parseDbf(b []byte, big5 bool) ([]map[string]interface{}, error) {
+	if len(b) < 32 {
+		return nil, fmt.Errorf("short header")
+	}
+	le := binary.LittleEndian
+	numRecords := int(le.Uint32(b[4:8]))
+	headerLen := int(le.Uint16(b[8:10]))
+	recordLen := int(le.Uint16(b[10:12]))
+	if headerLen > len(b) {
+		return nil, fmt.Errorf("header length %d overflows file of %d bytes", headerLen, len(b))
+	}
+
+	fields := []dbfField{}
+	// The deletion flag precedes the fields of a record.
+	fieldsLen := 1
+	for off := 32; off+dbfFieldDescLen <= headerLen && b[off] != dbfHeaderEnd; off += dbfFieldDescLen {
+		name := b[off : off+11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		fields = append(fields, dbfField{name: string(name), length: int(b[off+16])})
+		fieldsLen += int(b[off+16])
+	}
+	if fieldsLen > recordLen {
+		return nil, fmt.Errorf("fields of %d bytes overflow records of %d bytes", fieldsLen, recordLen)
+	}
+
+	decoder := traditionalchinese.Big5.NewDecoder()
+	records := make([]map[string]interface{}, 0, numRecords)
+	for i := 0; i < numRecords; i++ {
+		off := headerLen + i*recordLen
+		if off+recordLen > len(b) {
+			return nil, fmt.Errorf("record %d overflows file", i)
+		}
+		// Skip the deletion flag.
+		pos := off + 1
+		rec := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			v := b[pos : pos+f.length]
+			pos += f.length
+			if big5 {
+				decoded, err := decoder.Bytes(v)
+				if err != nil {
+					return nil, errors.Wrap(err, fmt.Sprintf("Big5.Decode record %d field %s", i, f.name))
+				}
+				v = decoded
+			}
+			rec[f.name] = strings.TrimSpace(string(bytes.TrimRight(v, "\x00")))
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
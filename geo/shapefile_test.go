@@ -0,0 +1,248 @@
+package geo
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// square returns a clockwise ring, an outer ring in shapefiles, of the square of side size at lng, lat.
+func square(lng, lat, size float64) []Point {
+	return []Point{{lat, lng}, {lat + size, lng}, {lat + size, lng + size}, {lat, lng + size}, {lat, lng}}
+}
+
+// reversed returns ring in the opposite direction, which makes outer rings holes.
+func reversed(ring []Point) []Point {
+	r := make([]Point, len(ring))
+	for i, p := range ring {
+		r[len(ring)-1-i] = p
+	}
+	return r
+}
+
+// shpBytes returns a shapefile of a polygon of rings for each shape, or a null shape for nil.
+func shpBytes(shapes ...[][]Point) []byte {
+	be, le := binary.BigEndian, binary.LittleEndian
+	b := make([]byte, shpHeaderLen)
+	be.PutUint32(b[0:4], shpFileCode)
+	for i, rings := range shapes {
+		content := make([]byte, 4)
+		if rings != nil {
+			le.PutUint32(content[0:4], shpPolygon)
+			// The bounding box is not read.
+			content = append(content, make([]byte, 40)...)
+			numPoints := 0
+			for _, ring := range rings {
+				content = append(content, 0, 0, 0, 0)
+				le.PutUint32(content[len(content)-4:], uint32(numPoints))
+				numPoints += len(ring)
+			}
+			le.PutUint32(content[36:40], uint32(len(rings)))
+			le.PutUint32(content[40:44], uint32(numPoints))
+			for _, ring := range rings {
+				for _, p := range ring {
+					xy := make([]byte, 16)
+					le.PutUint64(xy[0:8], math.Float64bits(p.Lng))
+					le.PutUint64(xy[8:16], math.Float64bits(p.Lat))
+					content = append(content, xy...)
+				}
+			}
+		}
+		header := make([]byte, 8)
+		be.PutUint32(header[0:4], uint32(i+1))
+		be.PutUint32(header[4:8], uint32(len(content)/2))
+		b = append(append(b, header...), content...)
+	}
+	return b
+}
+
+// dbfBytes returns a dbf of character fields and records of their values.
+func dbfBytes(fields []dbfField, records [][]string) []byte {
+	le := binary.LittleEndian
+	recordLen := 1
+	for _, f := range fields {
+		recordLen += f.length
+	}
+	headerLen := 32 + dbfFieldDescLen*len(fields) + 1
+	b := make([]byte, 32)
+	le.PutUint32(b[4:8], uint32(len(records)))
+	le.PutUint16(b[8:10], uint16(headerLen))
+	le.PutUint16(b[10:12], uint16(recordLen))
+	for _, f := range fields {
+		desc := make([]byte, dbfFieldDescLen)
+		copy(desc[0:11], f.name)
+		desc[11] = 'C'
+		desc[16] = byte(f.length)
+		b = append(b, desc...)
+	}
+	b = append(b, dbfHeaderEnd)
+	for _, rec := range records {
+		b = append(b, ' ')
+		for i, f := range fields {
+			v := []byte(rec[i] + strings.Repeat(" ", f.length))
+			b = append(b, v[:f.length]...)
+		}
+	}
+	return b
+}
+
+func writeShapefile(t *testing.T, shp, dbf []byte, cpg string) string {
+	base := filepath.Join(t.TempDir(), "test")
+	if err := ioutil.WriteFile(base+".shp", shp, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(base+".dbf", dbf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cpg != "" {
+		if err := ioutil.WriteFile(base+".cpg", []byte(cpg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base + ".shp"
+}
+
+func TestReadShapefile(t *testing.T) {
+	shp := shpBytes(
+		[][]Point{square(121, 25, 1), reversed(square(121.4, 25.4, 0.2))},
+		nil,
+		[][]Point{square(122, 25, 1)},
+	)
+	fields := []dbfField{{name: "CODE", length: 4}, {name: "NAME", length: 9}}
+	dbf := dbfBytes(fields, [][]string{{"A", "中正區"}, {"NULL", ""}, {"B", "大安區"}})
+	features, err := ReadShapefile(writeShapefile(t, shp, dbf, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Null shapes are skipped.
+	if len(features) != 2 {
+		t.Fatalf("%d features, want 2", len(features))
+	}
+	if code, name := features[0].StringProperty("CODE"), features[0].StringProperty("NAME"); code != "A" || name != "中正區" {
+		t.Errorf("properties of feature 0 are %s %s", code, name)
+	}
+	if code := features[1].StringProperty("CODE"); code != "B" {
+		t.Errorf("feature 1 has code %s, want B", code)
+	}
+
+	tests := []struct {
+		p    Point
+		want []bool
+	}{
+		{Point{25.2, 121.2}, []bool{true, false}},
+		// In the hole of feature 0.
+		{Point{25.5, 121.5}, []bool{false, false}},
+		{Point{25.5, 122.5}, []bool{false, true}},
+		{Point{24.5, 121.5}, []bool{false, false}},
+	}
+	for _, tt := range tests {
+		for i, f := range features {
+			if got := f.Geometry.Contains(tt.p); got != tt.want[i] {
+				t.Errorf("feature %d contains %v is %v, want %v", i, tt.p, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestReadShapefileBig5(t *testing.T) {
+	name, err := traditionalchinese.Big5.NewEncoder().String("中正區")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shp := shpBytes([][]Point{square(121, 25, 1)})
+	dbf := dbfBytes([]dbfField{{name: "NAME", length: 8}}, [][]string{{name}})
+	features, err := ReadShapefile(writeShapefile(t, shp, dbf, "BIG5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := features[0].StringProperty("NAME"); got != "中正區" {
+		t.Errorf("name %q, want 中正區", got)
+	}
+}
+
+func TestReadShapefileRecordCount(t *testing.T) {
+	shp := shpBytes([][]Point{square(121, 25, 1)}, [][]Point{square(122, 25, 1)})
+	dbf := dbfBytes([]dbfField{{name: "CODE", length: 4}}, [][]string{{"A"}})
+	if _, err := ReadShapefile(writeShapefile(t, shp, dbf, "")); err == nil {
+		t.Errorf("no error of 1 record for 2 shapes")
+	}
+}
+
+func TestParseShpErrors(t *testing.T) {
+	valid := shpBytes([][]Point{square(121, 25, 1)})
+
+	badCode := append([]byte{}, valid...)
+	binary.BigEndian.PutUint32(badCode[0:4], 1)
+	// The polygon has more points than its record.
+	manyPoints := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(manyPoints[shpHeaderLen+8+40:], 1000)
+	// A part starts after the points.
+	badPart := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(badPart[shpHeaderLen+8+44:], 6)
+	projected := shpBytes([][]Point{square(250000, 2700000, 1000)})
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"short header", valid[:shpHeaderLen-1]},
+		{"file code", badCode},
+		{"truncated record", valid[:len(valid)-8]},
+		{"points overflow record", manyPoints},
+		{"invalid part", badPart},
+		{"projected coordinates", projected},
+	}
+	for _, tt := range tests {
+		if _, err := parseShp(tt.b); err == nil {
+			t.Errorf("no error of %s", tt.name)
+		}
+	}
+	if _, err := parseShp(valid); err != nil {
+		t.Errorf("valid: %v", err)
+	}
+}
+
+func TestParseDbfErrors(t *testing.T) {
+	fields := []dbfField{{name: "CODE", length: 4}, {name: "NAME", length: 6}}
+	valid := dbfBytes(fields, [][]string{{"A", "a"}, {"B", "b"}})
+
+	// The header is longer than the file.
+	longHeader := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(longHeader[8:10], uint16(len(valid)+1))
+	// The fields are longer than the records, so they would read into the next record.
+	oversized := append([]byte{}, valid...)
+	oversized[32+dbfFieldDescLen+16] = 200
+	// The records are shorter than the fields.
+	shortRecords := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(shortRecords[10:12], 5)
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"short header", valid[:31]},
+		{"truncated header", valid[:32+dbfFieldDescLen]},
+		{"header length", longHeader},
+		{"truncated record", valid[:len(valid)-1]},
+		{"oversized field", oversized},
+		{"short records", shortRecords},
+	}
+	for _, tt := range tests {
+		if _, err := parseDbf(tt.b, false); err == nil {
+			t.Errorf("no error of %s", tt.name)
+		}
+	}
+
+	records, err := parseDbf(valid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1]["CODE"] != "B" || records[1]["NAME"] != "b" {
+		t.Errorf("got %v", records)
+	}
+}
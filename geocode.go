@@ -2,14 +2,17 @@ package housing
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
-	"time"
 
 	"github.com/pkg/errors"
 
+	"housing/gazetteer"
+	"housing/geo"
+	"housing/transaction"
 	"housing/util"
 )
 
@@ -21,6 +24,106 @@ func (e *GeocodeNoResultsError) Error() string {
 	return fmt.Sprintf("no geocoding results for %s", e.addr)
 }
 
+type GeocodeOutOfBoundsError struct {
+	addr string
+	lat  float64
+	lng  float64
+	area string
+}
+
+func (e *GeocodeOutOfBoundsError) Error() string {
+	return fmt.Sprintf("geocoding result %f,%f of %s outside of %s", e.lat, e.lng, e.addr, e.area)
+}
+
+// GeocodeErrorClass classifies the errors of the geocoding provider.
+type GeocodeErrorClass int
+
+const (
+	// GeocodeTransient errors, such as network errors and UNKNOWN_ERROR, may succeed when retried.
+	GeocodeTransient GeocodeErrorClass = iota
+	// GeocodeQuota errors mean that the request rate or the daily quota is exceeded.
+	GeocodeQuota
+	// GeocodeDenied errors mean that the request is rejected, usually because of the API key.
+	GeocodeDenied
+	// GeocodeInvalid errors mean that the request is malformed and will fail again when retried.
+	GeocodeInvalid
+)
+
+func (c GeocodeErrorClass) String() string {
+	switch c {
+	case GeocodeTransient:
+		return "transient"
+	case GeocodeQuota:
+		return "quota"
+	case GeocodeDenied:
+		return "denied"
+	case GeocodeInvalid:
+		return "invalid"
+	}
+	return fmt.Sprintf("GeocodeErrorClass(%d)", int(c))
+}
+
+type GeocodeError struct {
+	Class   GeocodeErrorClass
+	Status  string
+	Message string
+}
+
+func (e *GeocodeError) Error() string {
+	return fmt.Sprintf("google geo code %s error: %s %s", e.Class, e.Status, e.Message)
+}
+
+func (e *GeocodeError) Retryable() bool {
+	return e.Class == GeocodeTransient || e.Class == GeocodeQuota
+}
+
+// GeocodeCircuitOpenError is returned for every request after
+// the geocoder has stopped because of a denied request or an exhausted quota.
+type GeocodeCircuitOpenError struct {
+	cause error
+}
+
+func (e *GeocodeCircuitOpenError) Error() string {
+	return fmt.Sprintf("geocoder stopped: %v", e.cause)
+}
+
+type GeocodeBudgetExceededError struct {
+	stats GeocodeStats
+}
+
+func (e *GeocodeBudgetExceededError) Error() string {
+	return fmt.Sprintf("geocoding budget exceeded after %d live calls", e.stats.LiveCalls)
+}
+
+// APIKeyEnv is the environment variable of the Google Maps API key.
+const APIKeyEnv = "GCP_API_KEY"
+
+// PingAddress is geocoded by Ping.
+const PingAddress = "臺北市中正區重慶南路一段122號"
+
+// DefaultCostPerCall is the price in USD of a Google Maps Geocoding API request.
+const DefaultCostPerCall = 0.005
+
+// GeocodeBudget limits the live calls to the geocoding provider.
+// Zero values mean no limit.
+type GeocodeBudget struct {
+	MaxLiveCalls int
+	MaxCost      float64
+	CostPerCall  float64
+}
+
+type GeocodeStats struct {
+	CacheHits int
+	LiveCalls int
+	Failures  int
+	Retries   int
+}
+
+func (s GeocodeStats) Report(costPerCall float64) string {
+	return fmt.Sprintf("geocoding: %d cache hits, %d live calls, %d failures, %d retries, cost %.2f USD",
+		s.CacheHits, s.LiveCalls, s.Failures, s.Retries, float64(s.LiveCalls)*costPerCall)
+}
+
 type latlngprecision struct {
 	lat       float64
 	lng       float64
@@ -30,13 +133,26 @@ type latlngprecision struct {
 type Geocoder struct {
 	APIKey          string
 	PrecisionMeters float64
-	cache           map[string]latlngprecision
+	// Gazetteer, if set, is used to validate geocoding results and
+	// as a fallback when geocoding fails.
+	Gazetteer *gazetteer.Gazetteer
+	// RetryPolicy is used by GeocodeWithRetry for retryable errors.
+	RetryPolicy util.RetryPolicy
+	Budget      GeocodeBudget
+	// CacheOnly, if set, fails addresses missing from the cache with GeocodeNoResultsError instead of calling the API.
+	CacheOnly bool
+	stats     GeocodeStats
+	cache     map[string]latlngprecision
+	// tripped is the error that stopped the geocoder.
+	tripped error
 }
 
 func NewGeocoder(apiKey string, precision float64) *Geocoder {
 	g := Geocoder{
 		APIKey:          apiKey,
 		PrecisionMeters: precision,
+		RetryPolicy:     util.DefaultRetryPolicy,
+		Budget:          GeocodeBudget{CostPerCall: DefaultCostPerCall},
 		cache:           make(map[string]latlngprecision),
 	}
 	return &g
@@ -70,34 +186,113 @@ func (g *Geocoder) PopulateCache(fname string) error {
 }
 
 func (g *Geocoder) GeocodeWithRetry(addr string) (float64, float64, error) {
+	return g.GeocodeWithRetryContext(context.Background(), addr)
+}
+
+// GeocodeWithRetryContext retries retryable errors according to g.RetryPolicy, and always makes at least one attempt.
+// An exceeded quota that persists through all attempts stops the geocoder.
+func (g *Geocoder) GeocodeWithRetryContext(ctx context.Context, addr string) (float64, float64, error) {
 	var geocodeErr error
-	numRetries := 5
-	for i := 0; i < numRetries; i++ {
-		lat, lng, err := g.Geocode(addr)
+	numAttempts := g.RetryPolicy.Attempts()
+	for i := 0; i < numAttempts; i++ {
+		lat, lng, err := g.GeocodeContext(ctx, addr)
 		if err == nil {
 			return lat, lng, nil
 		}
-		if gnrErr, ok := err.(*GeocodeNoResultsError); ok {
-			return -1, -1, gnrErr
+		gErr, ok := err.(*GeocodeError)
+		if !ok || !gErr.Retryable() {
+			return -1, -1, err
 		}
 
 		geocodeErr = err
 
-		if i < numRetries-1 {
-			<-time.After(time.Duration(i) * time.Second)
+		if i < numAttempts-1 {
+			if err := g.RetryPolicy.Sleep(ctx, i); err != nil {
+				return -1, -1, errors.Wrap(err, "reversegeocode")
+			}
+			g.stats.Retries++
 		}
 	}
+	if gErr, ok := geocodeErr.(*GeocodeError); ok && gErr.Class == GeocodeQuota {
+		g.tripped = gErr
+	}
 	return -1, -1, errors.Wrap(geocodeErr, "reversegeocode")
 }
 
+// GeocodeTown geocodes addr, which is expected to be in the 鄉鎮市區 town of county.
+// Without a Gazetteer, it is equivalent to GeocodeWithRetry.
+// With a Gazetteer, results outside of town or Taiwan are rejected,
+// and the centroid of town is returned if addr cannot be geocoded.
+// The returned quality is one of the transaction.Location constants.
+func (g *Geocoder) GeocodeTown(addr, county, town string) (float64, float64, string, error) {
+	return g.GeocodeTownContext(context.Background(), addr, county, town)
+}
+
+func (g *Geocoder) GeocodeTownContext(ctx context.Context, addr, county, town string) (float64, float64, string, error) {
+	lat, lng, err := g.GeocodeWithRetryContext(ctx, addr)
+	if g.Gazetteer == nil {
+		return lat, lng, transaction.LocationGeocoded, err
+	}
+	if err != nil {
+		if _, ok := err.(*GeocodeNoResultsError); !ok {
+			return -1, -1, "", err
+		}
+	}
+
+	t := g.Gazetteer.Town(county, town)
+	if err == nil {
+		err = g.validate(addr, lat, lng, t)
+		if err == nil {
+			return lat, lng, transaction.LocationGeocoded, nil
+		}
+	}
+	if t == nil {
+		return -1, -1, "", err
+	}
+	return t.Centroid.Lat, t.Centroid.Lng, transaction.LocationCentroid, nil
+}
+
+func (g *Geocoder) validate(addr string, lat, lng float64, t *gazetteer.Town) error {
+	p := geo.Point{Lat: lat, Lng: lng}
+	if t != nil {
+		if !t.Contains(p) {
+			return &GeocodeOutOfBoundsError{addr: addr, lat: lat, lng: lng, area: t.County + t.Name}
+		}
+		return nil
+	}
+	if !g.Gazetteer.InTaiwan(p) {
+		return &GeocodeOutOfBoundsError{addr: addr, lat: lat, lng: lng, area: "臺灣"}
+	}
+	return nil
+}
+
 func (g *Geocoder) Geocode(addr string) (float64, float64, error) {
+	return g.GeocodeContext(context.Background(), addr)
+}
+
+func (g *Geocoder) GeocodeContext(ctx context.Context, addr string) (float64, float64, error) {
 	llp, ok := g.cache[addr]
 	if ok && llp.precision < g.PrecisionMeters {
+		g.stats.CacheHits++
 		return llp.lat, llp.lng, nil
 	}
 
-	lat, lng, err := g.geocode(addr)
+	if g.CacheOnly {
+		return -1, -1, &GeocodeNoResultsError{addr: addr}
+	}
+	if g.tripped != nil {
+		return -1, -1, &GeocodeCircuitOpenError{cause: g.tripped}
+	}
+	if g.overBudget() {
+		return -1, -1, &GeocodeBudgetExceededError{stats: g.stats}
+	}
+	g.stats.LiveCalls++
+	lat, lng, err := g.geocode(ctx, addr)
 	if err != nil {
+		g.stats.Failures++
+		if gErr, ok := err.(*GeocodeError); ok && gErr.Class == GeocodeDenied {
+			g.tripped = gErr
+		}
 		return -1, -1, err
 	}
 
@@ -105,7 +300,38 @@ func (g *Geocoder) Geocode(addr string) (float64, float64, error) {
 	return lat, lng, nil
 }
 
-func (g *Geocoder) geocode(addr string) (float64, float64, error) {
+// Ping checks the API key by geocoding PingAddress, bypassing the cache and the budget.
+func (g *Geocoder) Ping(ctx context.Context) error {
+	g.stats.LiveCalls++
+	if _, _, err := g.geocode(ctx, PingAddress); err != nil {
+		g.stats.Failures++
+		return err
+	}
+	return nil
+}
+
+// overBudget reports whether one more live call would exceed g.Budget.
+func (g *Geocoder) overBudget() bool {
+	b := g.Budget
+	if b.MaxLiveCalls > 0 && g.stats.LiveCalls >= b.MaxLiveCalls {
+		return true
+	}
+	if b.MaxCost > 0 && float64(g.stats.LiveCalls+1)*b.CostPerCall > b.MaxCost {
+		return true
+	}
+	return false
+}
+
+func (g *Geocoder) Stats() GeocodeStats {
+	return g.stats
+}
+
+// Report summarizes the calls and the cost of the geocoder.
+func (g *Geocoder) Report() string {
+	return g.stats.Report(g.Budget.CostPerCall)
+}
+
+func (g *Geocoder) geocode(ctx context.Context, addr string) (float64, float64, error) {
 	v := url.Values{
 		"key":     {g.APIKey},
 		"address": {addr},
@@ -120,17 +346,34 @@ func (g *Geocoder) geocode(addr string) (float64, float64, error) {
 				} `json:"location"`
 			} `json:"geometry"`
 		} `json:"results"`
-		Status string `json:"status"`
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
 	}{}
-	_, respBody, err := util.JSONReq3("GET", urlStr, &resp)
+	_, _, err := util.JSONReq3Context(ctx, "GET", urlStr, &resp)
 	if err != nil {
-		return -1, -1, errors.Wrap(err, "JSONReq3")
-	}
-	if resp.Status != "OK" {
-		if resp.Status == "ZERO_RESULTS" {
-			return -1, -1, &GeocodeNoResultsError{addr: addr}
+		// Errors of the request contain urlStr.
+		err = util.RedactError(err, g.APIKey)
+		if ctx.Err() != nil {
+			return -1, -1, errors.Wrap(err, "JSONReq3Context")
 		}
-		return -1, -1, fmt.Errorf("google geo code: %s", respBody)
+		return -1, -1, &GeocodeError{Class: GeocodeTransient, Message: err.Error()}
+	}
+	resp.ErrorMessage = util.Redact(resp.ErrorMessage, g.APIKey)
+	switch resp.Status {
+	case "OK":
+	case "ZERO_RESULTS":
+		return -1, -1, &GeocodeNoResultsError{addr: addr}
+	case "OVER_QUERY_LIMIT", "OVER_DAILY_LIMIT":
+		return -1, -1, &GeocodeError{Class: GeocodeQuota, Status: resp.Status, Message: resp.ErrorMessage}
+	case "REQUEST_DENIED":
+		return -1, -1, &GeocodeError{Class: GeocodeDenied, Status: resp.Status, Message: resp.ErrorMessage}
+	case "INVALID_REQUEST":
+		return -1, -1, &GeocodeError{Class: GeocodeInvalid, Status: resp.Status, Message: resp.ErrorMessage}
+	default:
+		return -1, -1, &GeocodeError{Class: GeocodeTransient, Status: resp.Status, Message: resp.ErrorMessage}
+	}
+	if len(resp.Results) == 0 {
+		return -1, -1, &GeocodeNoResultsError{addr: addr}
 	}
 
 	lat := resp.Results[0].Geometry.Location.Lat
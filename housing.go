@@ -2,6 +2,7 @@ package housing
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -130,9 +132,14 @@ func (p *parser) parseROCDateIfNotEmpty(s, desc string) int64 {
 	return p.parseROCDate(s, desc)
 }
 
-func ParseRow(row []string, geocoder *Geocoder) (*transaction.Transaction, error) {
+// ParseRow parses a row of a 實價登錄 file of the county of countyCode, see CountyCodeFromFilename.
+func ParseRow(countyCode string, row []string, geocoder *Geocoder) (*transaction.Transaction, error) {
+	return ParseRowContext(context.Background(), countyCode, row, geocoder)
+}
+
+func ParseRowContext(ctx context.Context, countyCode string, row []string, geocoder *Geocoder) (*transaction.Transaction, error) {
 	p := &parser{}
-	ts := transaction.Transaction{}
+	ts := transaction.Transaction{CountyCode: countyCode}
 	ts.A鄉鎮市區 = row[0]
 	ts.A交易標的 = row[1]
 	ts.A土地區段位置或建物區門牌 = row[2]
@@ -162,12 +169,16 @@ func ParseRow(row []string, geocoder *Geocoder) (*transaction.Transaction, error
 	ts.A備註 = row[26]
 	ts.A編號 = row[27]
 
-	lat, lng, err := geocoder.GeocodeWithRetry(row[2])
-	if err != nil {
-		return nil, errors.Wrap(err, "Geocode")
+	// Without a geocoder, the transaction has no location.
+	if geocoder != nil {
+		lat, lng, quality, err := geocoder.GeocodeTownContext(ctx, row[2], CountyName(countyCode), row[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "Geocode")
+		}
+		ts.Lat = lat
+		ts.Lng = lng
+		ts.LocationQuality = quality
 	}
-	ts.Lat = lat
-	ts.Lng = lng
 
 	if err := p.Error(); err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("%+v", row))
@@ -262,31 +273,71 @@ func ScanFile(fname string, rowFn func(fname string, rowID int, row []string) er
 	return nil
 }
 
-func ScanDir(dirname string, rowFn func(fname string, rowID int, row []string) error) error {
-	counties := make(map[string]string)
-	counties["C"] = "基隆市"
-	counties["A"] = "臺北市"
-	counties["F"] = "新北市"
-	counties["H"] = "桃園縣"
-	counties["O"] = "新竹市"
-	counties["J"] = "新竹縣"
-	counties["K"] = "苗栗縣"
-	counties["B"] = "臺中市"
-	counties["M"] = "南投縣"
-	counties["N"] = "彰化縣"
-	counties["P"] = "雲林縣"
-	counties["I"] = "嘉義市"
-	counties["Q"] = "嘉義縣"
-	counties["D"] = "臺南市"
-	counties["E"] = "高雄市"
-	counties["T"] = "屏東縣"
-	counties["G"] = "宜蘭縣"
-	counties["U"] = "花蓮縣"
-	counties["V"] = "臺東縣"
-	counties["X"] = "澎湖縣"
-	counties["W"] = "金門縣"
-	counties["Z"] = "連江縣"
+// counties maps the county codes used in the names of 實價登錄 files to county names.
+var counties = map[string]string{
+	"C": "基隆市",
+	"A": "臺北市",
+	"F": "新北市",
+	"H": "桃園縣",
+	"O": "新竹市",
+	"J": "新竹縣",
+	"K": "苗栗縣",
+	"B": "臺中市",
+	"M": "南投縣",
+	"N": "彰化縣",
+	"P": "雲林縣",
+	"I": "嘉義市",
+	"Q": "嘉義縣",
+	"D": "臺南市",
+	"E": "高雄市",
+	"T": "屏東縣",
+	"G": "宜蘭縣",
+	"U": "花蓮縣",
+	"V": "臺東縣",
+	"X": "澎湖縣",
+	"W": "金門縣",
+	"Z": "連江縣",
+}
+
+// CountyFromFilename returns the county of a 實價登錄 file such as A_lvr_land_A.CSV.
+func CountyFromFilename(fname string) string {
+	return counties[CountyCodeFromFilename(fname)]
+}
+
+// CountyCodeFromFilename returns the county code of a 實價登錄 file such as A_lvr_land_A.CSV.
+func CountyCodeFromFilename(fname string) string {
+	base := filepath.Base(fname)
+	i := strings.Index(base, "_")
+	if i < 0 {
+		return ""
+	}
+	if _, ok := counties[base[:i]]; !ok {
+		return ""
+	}
+	return base[:i]
+}
+
+// CountyName returns the name of the county code, or "" if code is unknown.
+func CountyName(code string) string {
+	return counties[code]
+}
+
+// CountyCode returns the county code of an address starting with the county, such as 臺北市大安區...
+func CountyCode(addr string) string {
+	addr = strings.Replace(addr, "台", "臺", -1)
+	// 桃園縣 became 桃園市 in 2014.
+	if strings.HasPrefix(addr, "桃園市") {
+		return "H"
+	}
+	for code, county := range counties {
+		if strings.HasPrefix(addr, county) {
+			return code
+		}
+	}
+	return ""
+}
 
+func ScanDir(dirname string, rowFn func(fname string, rowID int, row []string) error) error {
 	tradeTypes := make(map[string]string)
 	tradeTypes["A"] = "不動產買賣"
 	// We do not handle B:預售屋買賣 since they only have 地號 instead of addresses that are geocodable.
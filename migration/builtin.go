@@ -0,0 +1,29 @@
+package migration
+
+import (
+	"housing/publish"
+)
+
+func init() {
+	Register(Migration{
+		Name:        "summary",
+		Description: "rewrite bodies with a human readable summary and a schema version",
+		Version:     1,
+		New: func() Func {
+			// The body is rewritten by cmd/migrate anyway.
+			return func(m *Msg) error { return nil }
+		},
+	})
+	Register(Migration{
+		Name:        "sortkey",
+		Description: "derive sort keys from 編號 instead of random offsets within the day",
+		Version:     2,
+		New: func() Func {
+			keys := publish.NewSortKeys()
+			return func(m *Msg) error {
+				m.SKF64 = keys.Key(m.Transaction)
+				return nil
+			}
+		},
+	})
+}
@@ -0,0 +1,69 @@
+// Package migration is a registry of transformations of published messages, which are applied by cmd/migrate.
+package migration
+
+import (
+	"fmt"
+	"sort"
+
+	"housing/transaction"
+	"housing/util/jinma"
+)
+
+// Msg is a published message being migrated.
+type Msg struct {
+	// Orig is the message as published.
+	Orig jinma.Msg
+	// Transaction is the decoded body, including 編號, Lat and Lng of the message.
+	// Changing Lat or Lng moves the message.
+	Transaction transaction.Transaction
+	SKF64       float64
+}
+
+// Func transforms m in place.
+type Func func(m *Msg) error
+
+// Migration is a named transformation that brings bodies to a schema version.
+type Migration struct {
+	Name        string
+	Description string
+	// Version is the schema version of migrated bodies. Messages at or above it are skipped.
+	// A migration to a new version must also raise transaction.SchemaVersion, which transaction.DecodeBody reads up to.
+	Version int
+	// New returns the Func of a run, which may keep state across the messages of the run.
+	// The Func is called for every message in order, including skipped ones, so that its state is the same in every run.
+	New func() Func
+}
+
+var registry = make(map[string]Migration)
+
+// Register adds m to the registry. It panics if the name is taken, and is meant to be called in init functions.
+func Register(m Migration) {
+	if _, ok := registry[m.Name]; ok {
+		panic(fmt.Sprintf("migration %s registered twice", m.Name))
+	}
+	registry[m.Name] = m
+}
+
+// Get returns the migration of name.
+func Get(name string) (Migration, error) {
+	m, ok := registry[name]
+	if !ok {
+		return Migration{}, fmt.Errorf("unknown migration %s", name)
+	}
+	return m, nil
+}
+
+// All returns the registered migrations ordered by version and name.
+func All() []Migration {
+	all := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Version != all[j].Version {
+			return all[i].Version < all[j].Version
+		}
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
@@ -0,0 +1,118 @@
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"housing/transaction"
+)
+
+// DefaultBodyTemplate renders a summary such as 信義區 住宅大樓 3房2廳 35.2坪 總價 2,380萬 (單價 67.6萬/坪) 2017-06.
+const DefaultBodyTemplate = `{{.A鄉鎮市區}}
+{{- with buildingType .A建物型態}} {{.}}{{end}}
+{{- if .A建物現況格局_房}} {{.A建物現況格局_房}}房{{.A建物現況格局_廳}}廳{{end}}
+{{- if .A建物移轉總面積平方公尺}} {{ping .A建物移轉總面積平方公尺}}坪{{end}}
+{{- if .A總價元}} 總價 {{wan .A總價元}}萬{{end}}
+{{- if .A單價每平方公尺}} (單價 {{wanPerPing .A單價每平方公尺}}萬/坪){{end}}
+{{- with month .A交易年月日}} {{.}}{{end}}`
+
+const squareMetersPerPing = 3.305785
+
+var bodyFuncs = template.FuncMap{
+	"buildingType": buildingType,
+	"ping": func(m2 float64) string {
+		return fmt.Sprintf("%.1f", m2/squareMetersPerPing)
+	},
+	"wan": func(price int) string {
+		return thousands((price + 5000) / 10000)
+	},
+	"wanPerPing": func(pricePerM2 int) string {
+		return fmt.Sprintf("%.1f", float64(pricePerM2)*squareMetersPerPing/10000)
+	},
+	"month": func(date int64) string {
+		if date <= 0 {
+			return ""
+		}
+		return time.Unix(date, 0).UTC().Format("2006-01")
+	},
+}
+
+// thousands formats n with thousands separators.
+func thousands(n int) string {
+	if n < 0 {
+		return "-" + thousands(-n)
+	}
+	s := fmt.Sprintf("%d", n)
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// BodyRenderer renders the bodies of messages.
+// A body is a human readable summary of the transaction rendered by a text/template,
+// followed by the transaction in a transaction.Envelope on the last line for programs such as cmd/sync.
+type BodyRenderer struct {
+	tmpl *template.Template
+}
+
+// NewBodyRenderer returns a BodyRenderer of the template text, which is executed with a transaction.Transaction.
+func NewBodyRenderer(text string) (*BodyRenderer, error) {
+	tmpl, err := template.New("body").Funcs(bodyFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "template.Parse")
+	}
+	return &BodyRenderer{tmpl: tmpl}, nil
+}
+
+// LoadBodyRenderer returns a BodyRenderer of the template in fname, or of DefaultBodyTemplate if fname is empty.
+func LoadBodyRenderer(fname string) (*BodyRenderer, error) {
+	if fname == "" {
+		return NewBodyRenderer(DefaultBodyTemplate)
+	}
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadFile")
+	}
+	return NewBodyRenderer(string(b))
+}
+
+// MsgBody returns the body of the message of ts at transaction.SchemaVersion.
+func (r *BodyRenderer) MsgBody(ts transaction.Transaction) ([]byte, error) {
+	return r.VersionedMsgBody(ts, transaction.SchemaVersion)
+}
+
+// VersionedMsgBody returns the body of the message of ts marked with schema version.
+func (r *BodyRenderer) VersionedMsgBody(ts transaction.Transaction, version int) ([]byte, error) {
+	// These fields are unneeded because they are contained in the jinma.Msg itself.
+	stripped := ts
+	stripped.A編號 = ""
+	stripped.Lat = 0
+	stripped.Lng = 0
+	// The county is in the address, except for land 區段, and leaving it out keeps the bodies of published messages unchanged.
+	stripped.CountyCode = ""
+	line, err := transaction.EncodeBody(stripped, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var summary bytes.Buffer
+	if err := r.tmpl.Execute(&summary, ts); err != nil {
+		return nil, errors.Wrap(err, "template.Execute")
+	}
+	text := bytes.TrimSpace(summary.Bytes())
+	if len(text) == 0 {
+		return line, nil
+	}
+	return append(append(text, '\n'), line...), nil
+}
@@ -0,0 +1,124 @@
+package publish
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"housing/transaction"
+)
+
+// Kinds of hashtags.
+const (
+	TagDistrict     = "district"
+	TagBuildingType = "buildingType"
+	TagRooms        = "rooms"
+	TagPrice        = "price"
+	TagSeason       = "season"
+)
+
+var (
+	// DefaultTagKinds are all kinds of hashtags.
+	DefaultTagKinds = []string{TagDistrict, TagBuildingType, TagRooms, TagPrice, TagSeason}
+	// DefaultPriceBands are the bounds of the price bands in 萬元.
+	DefaultPriceBands = []int{500, 1000, 1500, 2000, 3000, 5000}
+)
+
+// Tagger derives the hashtags of transactions.
+type Tagger struct {
+	Kinds []string
+	// PriceBands are the increasing bounds of the price bands in 萬元.
+	PriceBands []int
+}
+
+// NewTagger returns a Tagger of the comma separated kinds and price bands, such as the values of command line flags.
+func NewTagger(kinds, priceBands string) (*Tagger, error) {
+	t := &Tagger{}
+	for _, k := range strings.Split(kinds, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		switch k {
+		case TagDistrict, TagBuildingType, TagRooms, TagPrice, TagSeason:
+		default:
+			return nil, fmt.Errorf("unknown hashtag kind %s", k)
+		}
+		t.Kinds = append(t.Kinds, k)
+	}
+	for _, b := range strings.Split(priceBands, ",") {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+		bound, err := strconv.Atoi(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price band %s", b)
+		}
+		if n := len(t.PriceBands); n > 0 && bound <= t.PriceBands[n-1] {
+			return nil, fmt.Errorf("price bands %s not increasing", priceBands)
+		}
+		t.PriceBands = append(t.PriceBands, bound)
+	}
+	return t, nil
+}
+
+// FormatPriceBands formats bands in the format of NewTagger.
+func FormatPriceBands(bands []int) string {
+	s := make([]string, len(bands))
+	for i, b := range bands {
+		s[i] = strconv.Itoa(b)
+	}
+	return strings.Join(s, ",")
+}
+
+// Tags returns the hashtags of ts. Kinds that ts lacks are omitted.
+// The result is empty but not nil for a Tagger without kinds, so that updates remove the hashtags of messages.
+func (t *Tagger) Tags(ts transaction.Transaction) []string {
+	tags := []string{}
+	for _, k := range t.Kinds {
+		tag := ""
+		switch k {
+		case TagDistrict:
+			tag = ts.A鄉鎮市區
+		case TagBuildingType:
+			tag = buildingType(ts.A建物型態)
+		case TagRooms:
+			if ts.A建物現況格局_房 > 0 {
+				tag = fmt.Sprintf("%d房", ts.A建物現況格局_房)
+			}
+		case TagPrice:
+			tag = t.priceBand(ts.A總價元)
+		case TagSeason:
+			tag = transaction.Season(ts.A交易年月日)
+		}
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// buildingType strips the description in parentheses, such as 住宅大樓(11層含以上有電梯).
+func buildingType(s string) string {
+	if i := strings.Index(s, "("); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func (t *Tagger) priceBand(price int) string {
+	if price <= 0 || len(t.PriceBands) == 0 {
+		return ""
+	}
+	wan := price / 10000
+	if wan < t.PriceBands[0] {
+		return fmt.Sprintf("總價%d萬以下", t.PriceBands[0])
+	}
+	for i := 1; i < len(t.PriceBands); i++ {
+		if wan < t.PriceBands[i] {
+			return fmt.Sprintf("總價%d至%d萬", t.PriceBands[i-1], t.PriceBands[i])
+		}
+	}
+	return fmt.Sprintf("總價%d萬以上", t.PriceBands[len(t.PriceBands)-1])
+}
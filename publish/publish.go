@@ -0,0 +1,115 @@
+// Package publish turns transactions into Jinma messages.
+package publish
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"housing/transaction"
+	"housing/util/jinma"
+)
+
+// DecodeBody decodes the transaction in the body of msg of any schema version,
+// and restores the fields that MsgBody moves to the message itself.
+func DecodeBody(msg jinma.Msg) (transaction.Transaction, error) {
+	ts, _, err := transaction.DecodeBody(msg.Body)
+	if err != nil {
+		return ts, errors.Wrap(err, "transaction.DecodeBody")
+	}
+	ts.A編號 = msg.CustomID
+	ts.Lat = msg.Lat
+	ts.Lng = msg.Lng
+	return ts, nil
+}
+
+const secondsPerDay = 24 * 60 * 60
+
+// SortKey returns the sort key of ts, the transaction date plus an offset within the day derived from a hash of 編號,
+// so that publishing the same transaction again gives the same key.
+// Use SortKeys to avoid collided keys.
+func SortKey(ts transaction.Transaction) float64 {
+	return sortKey(ts, 0)
+}
+
+func sortKey(ts transaction.Transaction, salt int) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(ts.A編號))
+	if salt > 0 {
+		fmt.Fprintf(h, "#%d", salt)
+	}
+	// The top 53 bits of the hash are a uniform fraction in [0, 1).
+	frac := float64(h.Sum64()>>11) / (1 << 53)
+	day := float64(ts.A交易年月日)
+	skf64 := day + frac*secondsPerDay
+	// Rounding may carry the key to the next day.
+	if skf64 >= day+secondsPerDay {
+		skf64 = math.Nextafter(day+secondsPerDay, day)
+	}
+	return skf64
+}
+
+// SortKeys assigns sort keys that are unique within each day.
+// A transaction whose key collides with that of an earlier transaction gets the key of its rehashed 編號 instead,
+// so keys are stable as long as the transactions are assigned in the same order.
+type SortKeys struct {
+	mu    sync.Mutex
+	owner map[float64]string
+	keys  map[string]float64
+}
+
+func NewSortKeys() *SortKeys {
+	return &SortKeys{owner: make(map[float64]string), keys: make(map[string]float64)}
+}
+
+// Key returns the sort key of ts, assigning it if necessary.
+func (k *SortKeys) Key(ts transaction.Transaction) float64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if skf64, ok := k.keys[ts.A編號]; ok && SortKeyMatches(ts, skf64) {
+		return skf64
+	}
+	for salt := 0; ; salt++ {
+		skf64 := sortKey(ts, salt)
+		if owner, ok := k.owner[skf64]; ok && owner != ts.A編號 {
+			continue
+		}
+		k.owner[skf64] = ts.A編號
+		k.keys[ts.A編號] = skf64
+		return skf64
+	}
+}
+
+// SortKeyMatches reports whether skf64 is a sort key of ts, that is whether it is within the day of ts.
+func SortKeyMatches(ts transaction.Transaction, skf64 float64) bool {
+	day := float64(ts.A交易年月日)
+	return skf64 >= day && skf64 < day+24*60*60
+}
+
+// Create publishes ts with body, the sort key skf64 and hashtags.
+func Create(ctx context.Context, client *jinma.Client, ts transaction.Transaction, body []byte, skf64 float64, hashtags []string) (*jinma.Msg, error) {
+	customID := ts.A編號
+	if customID == "" {
+		return nil, fmt.Errorf("empty customID for %+v", ts)
+	}
+
+	msg, err := client.MsgCreate(ctx, string(body), ts.Lat, ts.Lng, &skf64, hashtags, customID)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.MsgCreate")
+	}
+	return msg, nil
+}
+
+// Update replaces the body, sort key and hashtags of message msgID.
+// Nil hashtags are left unchanged.
+func Update(ctx context.Context, client *jinma.Client, msgID string, body []byte, skf64 float64, hashtags []string) (*jinma.Msg, error) {
+	msg, err := client.MsgUpdate(ctx, msgID, body, &skf64, hashtags)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.MsgUpdate")
+	}
+	return msg, nil
+}
@@ -0,0 +1,112 @@
+package publish
+
+import (
+	"fmt"
+	"testing"
+
+	"housing/transaction"
+)
+
+const testDate = 1497484800
+
+func TestSortKeyStable(t *testing.T) {
+	// The keys of published messages must not change with the hash or its Go version.
+	tests := []struct {
+		id   string
+		want float64
+	}{
+		{"RPTEST0001", 1.4975023540543745e+09},
+		{"RPOOMLQJJHIFFAA67CA", 1.497490504435492e+09},
+	}
+	for _, tt := range tests {
+		ts := transaction.Transaction{A編號: tt.id, A交易年月日: testDate}
+		if got := SortKey(ts); got != tt.want {
+			t.Errorf("SortKey of %s = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestSortKeyWithinDay(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		ts := transaction.Transaction{A編號: fmt.Sprintf("RPTEST%04d", i), A交易年月日: testDate}
+		skf64 := SortKey(ts)
+		if skf64 < testDate || skf64 >= testDate+secondsPerDay || !SortKeyMatches(ts, skf64) {
+			t.Errorf("SortKey of %s = %f, not within day %d", ts.A編號, skf64, testDate)
+		}
+	}
+}
+
+func TestSortKeyMatches(t *testing.T) {
+	ts := transaction.Transaction{A編號: "RPTEST0001", A交易年月日: testDate}
+	tests := []struct {
+		skf64 float64
+		want  bool
+	}{
+		{testDate, true},
+		{testDate + secondsPerDay - 0.5, true},
+		{testDate + secondsPerDay, false},
+		{testDate - 0.5, false},
+		// Keys assigned before sort keys were derived from 編號.
+		{0, false},
+		{1, false},
+	}
+	for _, tt := range tests {
+		if got := SortKeyMatches(ts, tt.skf64); got != tt.want {
+			t.Errorf("SortKeyMatches(%f) = %v, want %v", tt.skf64, got, tt.want)
+		}
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	var tss []transaction.Transaction
+	for i := 0; i < 100; i++ {
+		tss = append(tss, transaction.Transaction{A編號: fmt.Sprintf("RPTEST%04d", i), A交易年月日: testDate})
+	}
+	keys := NewSortKeys()
+	assigned := make(map[float64]string)
+	for _, ts := range tss {
+		skf64 := keys.Key(ts)
+		if skf64 != SortKey(ts) {
+			t.Errorf("key of %s is %f without collisions, want %f", ts.A編號, skf64, SortKey(ts))
+		}
+		if keys.Key(ts) != skf64 {
+			t.Errorf("key of %s changed", ts.A編號)
+		}
+		assigned[skf64] = ts.A編號
+	}
+	if len(assigned) != len(tss) {
+		t.Errorf("%d keys of %d transactions", len(assigned), len(tss))
+	}
+
+	// A transaction whose date is revised gets a key in its new day.
+	ts := tss[0]
+	ts.A交易年月日 += secondsPerDay
+	if skf64 := keys.Key(ts); !SortKeyMatches(ts, skf64) {
+		t.Errorf("key of revised %s is %f, not in its new day", ts.A編號, skf64)
+	}
+}
+
+func TestSortKeysCollision(t *testing.T) {
+	a := transaction.Transaction{A編號: "RPTEST0001", A交易年月日: testDate}
+	b := transaction.Transaction{A編號: "RPTEST0002", A交易年月日: testDate}
+	// Make the FNV hash of b collide with that of a, as if a was assigned it first.
+	keys := NewSortKeys()
+	collided := sortKey(b, 0)
+	keys.owner[collided] = a.A編號
+	keys.keys[a.A編號] = collided
+
+	skf64 := keys.Key(b)
+	if skf64 != sortKey(b, 1) {
+		t.Errorf("key of colliding %s is %f, want the salted %f", b.A編號, skf64, sortKey(b, 1))
+	}
+	if skf64 == collided || !SortKeyMatches(b, skf64) {
+		t.Errorf("salted key %f of %s collides or is not within its day", skf64, b.A編號)
+	}
+	if keys.Key(b) != skf64 {
+		t.Errorf("salted key of %s changed", b.A編號)
+	}
+	// The earlier transaction keeps its key.
+	if got := keys.Key(a); got != collided {
+		t.Errorf("key of %s is %f, want %f", a.A編號, got, collided)
+	}
+}
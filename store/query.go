@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"housing/geo"
+	"housing/transaction"
+)
+
+// Range is an inclusive range of a column. Nil bounds are unbounded.
+type Range struct {
+	Min *float64
+	Max *float64
+}
+
+// Query selects transactions. Zero values match all transactions.
+type Query struct {
+	CountyCode string
+	// District is 鄉鎮市區.
+	District string
+	// BuildingType matches 建物型態 by prefix, such as 住宅大樓.
+	BuildingType string
+	// Date is the range of 交易年月日 in Unix time.
+	Date Range
+	// Rooms is the range of 建物現況格局_房.
+	Rooms Range
+	// Price is the range of 總價元.
+	Price Range
+	// UnitPrice is the range of 單價每平方公尺.
+	UnitPrice Range
+	// Area is the range of 建物移轉總面積平方公尺.
+	Area Range
+	// BBox, if set, matches transactions located in it.
+	BBox *geo.Rect
+
+	// Sort is a key of SortKeys, prefixed with - for descending order. Transactions are ordered by 編號 last.
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// SortKeys maps the sort keys of Query to columns.
+var SortKeys = map[string]string{
+	"id":        columnID,
+	"date":      columnDate,
+	"price":     columnPrice,
+	"unitPrice": columnUnitPrice,
+	"area":      columnArea,
+	"rooms":     columnRooms,
+}
+
+// where returns the WHERE clause of q and its arguments.
+func (q *Query) where() (string, []interface{}) {
+	conds := []string{}
+	args := []interface{}{}
+	eq := func(col, v string) {
+		if v != "" {
+			conds = append(conds, quote(col)+" = ?")
+			args = append(args, v)
+		}
+	}
+	between := func(col string, r Range) {
+		if r.Min != nil {
+			conds = append(conds, quote(col)+" >= ?")
+			args = append(args, *r.Min)
+		}
+		if r.Max != nil {
+			conds = append(conds, quote(col)+" <= ?")
+			args = append(args, *r.Max)
+		}
+	}
+
+	eq(ColumnCountyCode, q.CountyCode)
+	eq(columnDistrict, q.District)
+	if q.BuildingType != "" {
+		conds = append(conds, quote(columnBuilding)+" LIKE ? ESCAPE '\\'")
+		args = append(args, escapeLike(q.BuildingType)+"%")
+	}
+	between(columnDate, q.Date)
+	between(columnRooms, q.Rooms)
+	between(columnPrice, q.Price)
+	between(columnUnitPrice, q.UnitPrice)
+	between(columnArea, q.Area)
+	if q.BBox != nil {
+		conds = append(conds, fmt.Sprintf("%s != ?", quote(ColumnLocationQuality)))
+		args = append(args, LocationMissing)
+		between(columnLat, Range{Min: &q.BBox.Min.Lat, Max: &q.BBox.Max.Lat})
+		between(columnLng, Range{Min: &q.BBox.Min.Lng, Max: &q.BBox.Max.Lng})
+	}
+
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// orderBy returns the ORDER BY clause of q.
+func (q *Query) orderBy() (string, error) {
+	if q.Sort == "" {
+		return " ORDER BY " + quote(columnID), nil
+	}
+	key, dir := q.Sort, "ASC"
+	if strings.HasPrefix(key, "-") {
+		key, dir = key[1:], "DESC"
+	}
+	col, ok := SortKeys[key]
+	if !ok {
+		return "", fmt.Errorf("unknown sort key %s", key)
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, %s", quote(col), dir, quote(columnID)), nil
+}
+
+// Count returns the number of transactions matching q, ignoring its Limit and Offset.
+func (s *Store) Count(ctx context.Context, q Query) (int, error) {
+	where, args := q.where()
+	var n int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+TransactionsTable+where, args...).Scan(&n); err != nil {
+		return 0, errors.Wrap(err, "Count")
+	}
+	return n, nil
+}
+
+// Find calls fn with the transactions matching q in order.
+func (s *Store) Find(ctx context.Context, q Query, fn func(ts transaction.Transaction) error) error {
+	where, args := q.where()
+	order, err := q.orderBy()
+	if err != nil {
+		return err
+	}
+	stmt := fmt.Sprintf("SELECT %s FROM %s%s%s", quote(ColumnJSON), TransactionsTable, where, order)
+	if q.Limit > 0 || q.Offset > 0 {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		stmt += " LIMIT ? OFFSET ?"
+		args = append(args, limit, q.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return errors.Wrap(err, "QueryContext")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var js sql.RawBytes
+		if err := rows.Scan(&js); err != nil {
+			return errors.Wrap(err, "Scan")
+		}
+		ts := transaction.Transaction{}
+		if err := json.Unmarshal(js, &ts); err != nil {
+			return errors.Wrap(err, "json.Unmarshal")
+		}
+		if err := fn(ts); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(rows.Err(), "rows.Err")
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"housing/transaction"
+)
+
+// Tables of the store.
+const (
+	// TransactionsTable has the latest version of every transaction, keyed by 編號.
+	TransactionsTable = "transactions"
+	// HistoryTable has the replaced versions of revised transactions, with the time they were replaced in RevisedAt.
+	HistoryTable = "transaction_history"
+)
+
+// Columns that are not fields of transaction.Transaction.
+const (
+	// ColumnSeason is the ROC year and quarter of 交易年月日, such as 109年第3季.
+	ColumnSeason = "Season"
+	// ColumnLocationQuality is one of LocationGeocoded, LocationCentroid and LocationMissing.
+	ColumnLocationQuality = "LocationQuality"
+	// ColumnJSON is the transaction as written by cmd/parse.
+	ColumnJSON = "JSON"
+	// ColumnLoadedAt is the Unix time the row was last written.
+	ColumnLoadedAt = "LoadedAt"
+	// ColumnRevisedAt is the Unix time a row of HistoryTable was replaced.
+	ColumnRevisedAt = "RevisedAt"
+)
+
+// ColumnCountyCode is the column of transaction.Transaction.CountyCode, which Upsert requires.
+const ColumnCountyCode = "CountyCode"
+
+// Values of ColumnLocationQuality.
+const (
+	LocationGeocoded = transaction.LabelGeocoded
+	LocationCentroid = transaction.LabelCentroid
+	LocationMissing  = transaction.LabelMissing
+)
+
+// column is a column of TransactionsTable.
+type column struct {
+	name    string
+	sqlType string
+	// field is the index of the field of transaction.Transaction, or -1 for derived columns.
+	field int
+}
+
+// columns are derived from the fields of transaction.Transaction, so that new fields are added to existing stores by Open.
+var columns = transactionColumns()
+
+func transactionColumns() []column {
+	cols := []column{}
+	t := reflect.TypeOf(transaction.Transaction{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		// Replaced by the derived ColumnLocationQuality, which tells missing locations apart.
+		if name == ColumnLocationQuality {
+			continue
+		}
+		var sqlType string
+		switch f.Type.Kind() {
+		case reflect.String:
+			sqlType = "TEXT"
+		case reflect.Int, reflect.Int64:
+			sqlType = "INTEGER"
+		case reflect.Float64:
+			sqlType = "REAL"
+		default:
+			panic(fmt.Sprintf("unsupported type %s of Transaction.%s", f.Type, f.Name))
+		}
+		cols = append(cols, column{name: name, sqlType: sqlType, field: i})
+	}
+	return append(cols,
+		column{name: ColumnSeason, sqlType: "TEXT", field: -1},
+		column{name: ColumnLocationQuality, sqlType: "TEXT", field: -1},
+		column{name: ColumnJSON, sqlType: "TEXT", field: -1},
+		column{name: ColumnLoadedAt, sqlType: "INTEGER", field: -1},
+	)
+}
+
+// Column names of the fields of transaction.Transaction that are keys, indexed or queried.
+const (
+	columnID        = "編號"
+	columnDistrict  = "鄉鎮市區"
+	columnDate      = "交易年月日"
+	columnPrice     = "總價元"
+	columnUnitPrice = "單價每平方公尺"
+	columnArea      = "建物移轉總面積平方公尺"
+	columnRooms     = "建物現況格局_房"
+	columnBuilding  = "建物型態"
+	columnLat       = "Lat"
+	columnLng       = "Lng"
+)
+
+// indexes of TransactionsTable, by name and columns.
+var indexes = []struct {
+	name    string
+	columns []string
+}{
+	{"transactions_district", []string{ColumnCountyCode, columnDistrict}},
+	{"transactions_date", []string{columnDate}},
+	{"transactions_price", []string{columnPrice}},
+	{"transactions_unit_price", []string{columnUnitPrice}},
+	{"transactions_location", []string{columnLat, columnLng}},
+}
+
+func quote(name string) string {
+	return `"` + name + `"`
+}
+
+func quoteAll(names []string) []string {
+	quoted := make([]string, 0, len(names))
+	for _, n := range names {
+		quoted = append(quoted, quote(n))
+	}
+	return quoted
+}
+
+func columnNames(cols []column) []string {
+	names := make([]string, 0, len(cols))
+	for _, c := range cols {
+		names = append(names, quote(c.name))
+	}
+	return names
+}
+
+// columnDefs returns the definitions of columns, with 編號 as the primary key or as a plain key of HistoryTable.
+func columnDefs(primaryKey bool) []string {
+	defs := []string{}
+	for _, c := range columns {
+		def := quote(c.name) + " " + c.sqlType
+		if c.name == columnID {
+			if primaryKey {
+				def += " PRIMARY KEY"
+			} else {
+				def += " NOT NULL"
+			}
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// schema returns the statements creating the tables and indexes.
+func schema() []string {
+	historyDefs := append(columnDefs(false), quote(ColumnRevisedAt)+" INTEGER")
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", TransactionsTable, strings.Join(columnDefs(true), ", ")),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", HistoryTable, strings.Join(historyDefs, ", ")),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS transaction_history_id ON %s (%s)", HistoryTable, quote(columnID)),
+	}
+	for _, idx := range indexes {
+		stmts = append(stmts, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+			idx.name, TransactionsTable, strings.Join(quoteAll(idx.columns), ", ")))
+	}
+	return stmts
+}
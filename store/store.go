@@ -0,0 +1,236 @@
+// Package store keeps parsed transactions in an embedded SQLite database for ad hoc queries.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	// The SQLite driver in pure Go, registered as "sqlite".
+	_ "modernc.org/sqlite"
+
+	"housing/transaction"
+)
+
+// Store is a SQLite database of transactions.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens or creates the store in the file fname, and adds the columns of new fields of transaction.Transaction.
+func Open(fname string) (*Store, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", fname))
+	if err != nil {
+		return nil, errors.Wrap(err, "sql.Open")
+	}
+	s := &Store{db: db}
+	if err := s.createSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenReadOnly opens the existing store in the file fname for queries only.
+func OpenReadOnly(fname string) (*Store, error) {
+	if _, err := os.Stat(fname); err != nil {
+		return nil, errors.Wrap(err, "os.Stat")
+	}
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", fname))
+	if err != nil {
+		return nil, errors.Wrap(err, "sql.Open")
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) createSchema() error {
+	for _, stmt := range schema() {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return errors.Wrap(err, stmt)
+		}
+	}
+	for _, table := range []string{TransactionsTable, HistoryTable} {
+		existing, err := s.tableColumns(table)
+		if err != nil {
+			return err
+		}
+		for _, c := range columns {
+			if existing[c.name] {
+				continue
+			}
+			stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, quote(c.name), c.sqlType)
+			if _, err := s.db.Exec(stmt); err != nil {
+				return errors.Wrap(err, stmt)
+			}
+		}
+	}
+	return nil
+}
+
+// tableColumns returns the set of the columns of table.
+func (s *Store) tableColumns(table string) (map[string]bool, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return nil, errors.Wrap(err, "pragma_table_info")
+	}
+	defer rows.Close()
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "Scan")
+		}
+		names[name] = true
+	}
+	return names, errors.Wrap(rows.Err(), "rows.Err")
+}
+
+// DB returns the database of s.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Result is the outcome of Upsert.
+type Result int
+
+const (
+	Unchanged Result = iota
+	Inserted
+	Updated
+)
+
+func (r Result) String() string {
+	switch r {
+	case Inserted:
+		return "inserted"
+	case Updated:
+		return "updated"
+	}
+	return "unchanged"
+}
+
+// Batch upserts transactions in a single database transaction, which is much faster than one transaction per row.
+type Batch struct {
+	tx      *sql.Tx
+	get     *sql.Stmt
+	history *sql.Stmt
+	upsert  *sql.Stmt
+}
+
+// Begin starts a Batch.
+func (s *Store) Begin(ctx context.Context) (*Batch, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "BeginTx")
+	}
+	b := &Batch{tx: tx}
+	names := columnNames(columns)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	updates := []string{}
+	for _, n := range names {
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", n, n))
+	}
+	stmts := []struct {
+		stmt **sql.Stmt
+		sql  string
+	}{
+		{&b.get, fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", quote(ColumnJSON), TransactionsTable, quote(columnID))},
+		{&b.history, fmt.Sprintf("INSERT INTO %s (%s, %s) SELECT %s, ? FROM %s WHERE %s = ?",
+			HistoryTable, strings.Join(names, ", "), quote(ColumnRevisedAt), strings.Join(names, ", "), TransactionsTable, quote(columnID))},
+		{&b.upsert, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+			TransactionsTable, strings.Join(names, ", "), placeholders, quote(columnID), strings.Join(updates, ", "))},
+	}
+	for _, st := range stmts {
+		if *st.stmt, err = tx.PrepareContext(ctx, st.sql); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrap(err, st.sql)
+		}
+	}
+	return b, nil
+}
+
+// Upsert inserts ts, or updates the transaction of the same 編號 after copying it to HistoryTable if it differs.
+func (b *Batch) Upsert(ctx context.Context, ts transaction.Transaction) (Result, error) {
+	if ts.A編號 == "" {
+		return Unchanged, fmt.Errorf("no 編號")
+	}
+	if ts.CountyCode == "" {
+		return Unchanged, fmt.Errorf("no CountyCode")
+	}
+	js, err := json.Marshal(ts)
+	if err != nil {
+		return Unchanged, errors.Wrap(err, "json.Marshal")
+	}
+
+	result := Inserted
+	var old string
+	err = b.get.QueryRowContext(ctx, ts.A編號).Scan(&old)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return Unchanged, errors.Wrap(err, "get")
+	case old == string(js):
+		return Unchanged, nil
+	default:
+		result = Updated
+	}
+
+	now := time.Now().Unix()
+	if result == Updated {
+		if _, err := b.history.ExecContext(ctx, now, ts.A編號); err != nil {
+			return Unchanged, errors.Wrap(err, "history")
+		}
+	}
+	if _, err := b.upsert.ExecContext(ctx, values(ts, string(js), now)...); err != nil {
+		return Unchanged, errors.Wrap(err, "upsert")
+	}
+	return result, nil
+}
+
+// values returns the values of columns for ts.
+func values(ts transaction.Transaction, js string, now int64) []interface{} {
+	v := reflect.ValueOf(ts)
+	vals := make([]interface{}, 0, len(columns))
+	for _, c := range columns {
+		if c.field >= 0 {
+			vals = append(vals, v.Field(c.field).Interface())
+			continue
+		}
+		switch c.name {
+		case ColumnSeason:
+			vals = append(vals, transaction.Season(ts.A交易年月日))
+		case ColumnLocationQuality:
+			vals = append(vals, LocationQualityOf(ts))
+		case ColumnJSON:
+			vals = append(vals, js)
+		case ColumnLoadedAt:
+			vals = append(vals, now)
+		default:
+			panic(fmt.Sprintf("no value of column %s", c.name))
+		}
+	}
+	return vals
+}
+
+// LocationQualityOf returns the ColumnLocationQuality of ts.
+func LocationQualityOf(ts transaction.Transaction) string {
+	return ts.LocationLabel()
+}
+
+func (b *Batch) Commit() error {
+	return errors.Wrap(b.tx.Commit(), "Commit")
+}
+
+func (b *Batch) Rollback() error {
+	return errors.Wrap(b.tx.Rollback(), "Rollback")
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"housing/transaction"
+)
+
+func testTransaction(i int) transaction.Transaction {
+	return transaction.Transaction{
+		A編號:        fmt.Sprintf("RPTEST%04d", i),
+		A鄉鎮市區:      "大安區",
+		A建物型態:      "住宅大樓(11層含以上有電梯)",
+		A交易年月日:     1497484800,
+		A總價元:       10000000 + i,
+		Lat:        25.03,
+		Lng:        121.54,
+		CountyCode: "A",
+	}
+}
+
+func openStore(t *testing.T) *Store {
+	s, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// load upserts tss in a batch and returns the counts of the results.
+func load(t *testing.T, s *Store, tss []transaction.Transaction) map[Result]int {
+	ctx := context.Background()
+	b, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[Result]int)
+	for _, ts := range tss {
+		r, err := b.Upsert(ctx, ts)
+		if err != nil {
+			b.Rollback()
+			t.Fatal(err)
+		}
+		counts[r]++
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func TestUpsertHistory(t *testing.T) {
+	s := openStore(t)
+	tss := []transaction.Transaction{testTransaction(0), testTransaction(1), testTransaction(2)}
+	if counts := load(t, s, tss); counts[Inserted] != 3 {
+		t.Fatalf("first load %v", counts)
+	}
+	if counts := load(t, s, tss); counts[Unchanged] != 3 {
+		t.Fatalf("second load %v", counts)
+	}
+
+	// Revise the price of a transaction.
+	revised := tss[1]
+	revised.A總價元 = 9000000
+	tss[1] = revised
+	if counts := load(t, s, tss); counts[Updated] != 1 || counts[Unchanged] != 2 {
+		t.Fatalf("load of the revision %v", counts)
+	}
+
+	var price int
+	row := s.DB().QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", quote(columnPrice), TransactionsTable, quote(columnID)), revised.A編號)
+	if err := row.Scan(&price); err != nil {
+		t.Fatal(err)
+	}
+	if price != revised.A總價元 {
+		t.Errorf("price %d, want the revised %d", price, revised.A總價元)
+	}
+
+	rows, err := s.DB().Query(fmt.Sprintf("SELECT %s, %s, %s FROM %s", quote(columnID), quote(columnPrice), quote(ColumnRevisedAt), HistoryTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var id string
+		var revisedAt int64
+		if err := rows.Scan(&id, &price, &revisedAt); err != nil {
+			t.Fatal(err)
+		}
+		if id != revised.A編號 || price != testTransaction(1).A總價元 || revisedAt == 0 {
+			t.Errorf("history row %s %d %d, want the replaced row of %s", id, price, revisedAt, revised.A編號)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d history rows, want 1", n)
+	}
+}
+
+func TestUpsertRequiredFields(t *testing.T) {
+	s := openStore(t)
+	ctx := context.Background()
+	b, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Rollback()
+	noID := testTransaction(0)
+	noID.A編號 = ""
+	noCounty := testTransaction(0)
+	noCounty.CountyCode = ""
+	for _, ts := range []transaction.Transaction{noID, noCounty} {
+		if _, err := b.Upsert(ctx, ts); err == nil {
+			t.Errorf("no error of %+v", ts)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	s := openStore(t)
+	tss := []transaction.Transaction{}
+	for i := 0; i < 5; i++ {
+		tss = append(tss, testTransaction(i))
+	}
+	other := testTransaction(5)
+	other.CountyCode = "F"
+	other.A鄉鎮市區 = "板橋區"
+	load(t, s, append(tss, other))
+
+	ctx := context.Background()
+	min := float64(10000002)
+	q := Query{CountyCode: "A", Price: Range{Min: &min}, Sort: "-price", Limit: 2}
+	n, err := s.Count(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("count %d, want 3", n)
+	}
+	got := []string{}
+	err = s.Find(ctx, q, func(ts transaction.Transaction) error {
+		got = append(got, ts.A編號)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[RPTEST0004 RPTEST0003]"; fmt.Sprint(got) != want {
+		t.Errorf("found %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package transaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SchemaVersion is the schema version of the bodies of published messages written by this version of the code.
+// Version 0 bodies are the JSON of a Transaction without 編號, Lat and Lng, which are the CustomID and location of the message,
+// and without CountyCode, optionally after a summary line. Version 1 bodies are a summary line and an Envelope of the JSON of version 0.
+// Version 2 is version 1 with sort keys derived from 編號.
+const SchemaVersion = 2
+
+// Envelope is the last line of versioned bodies.
+type Envelope struct {
+	Version     int
+	Transaction json.RawMessage
+}
+
+// EncodeBody returns ts in an Envelope of version, the last line of a body.
+func EncodeBody(ts Transaction, version int) ([]byte, error) {
+	tsbody, err := json.Marshal(ts)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal")
+	}
+	b, err := json.Marshal(Envelope{Version: version, Transaction: tsbody})
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal Envelope")
+	}
+	return b, nil
+}
+
+// DecodeBody decodes the transaction in a body of any schema version up to SchemaVersion, and returns it with its version.
+// Fields that are not in the body, such as 編號, Lat, Lng and CountyCode, are left zero.
+func DecodeBody(body string) (Transaction, int, error) {
+	ts := Transaction{}
+	tsbody, version := parseBody(body)
+	if version > SchemaVersion {
+		return ts, version, fmt.Errorf("body version %d is newer than %d", version, SchemaVersion)
+	}
+	if err := json.Unmarshal(tsbody, &ts); err != nil {
+		return ts, version, errors.Wrap(err, fmt.Sprintf("json.Unmarshal body version %d", version))
+	}
+	return ts, version, nil
+}
+
+// BodyVersion returns the schema version of a body.
+func BodyVersion(body string) int {
+	_, version := parseBody(body)
+	return version
+}
+
+// parseBody returns the transaction JSON and the schema version of a body.
+func parseBody(body string) ([]byte, int) {
+	// The JSON is the last line, after the summary if any.
+	line := []byte(body[strings.LastIndexByte(body, '\n')+1:])
+	// Version 0 bodies are objects with Chinese keys, which unmarshal to an empty Envelope.
+	env := Envelope{}
+	if err := json.Unmarshal(line, &env); err != nil || len(env.Transaction) == 0 {
+		return line, 0
+	}
+	return env.Transaction, env.Version
+}
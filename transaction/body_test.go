@@ -0,0 +1,79 @@
+package transaction
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	ts := Transaction{A鄉鎮市區: "大安區", A交易年月日: 1497484800, A總價元: 12000000}
+	v0, err := json.Marshal(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := EncodeBody(ts, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := EncodeBody(ts, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, err := EncodeBody(ts, SchemaVersion+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := "大安區 1200萬\n106年6月"
+
+	tests := []struct {
+		name    string
+		body    string
+		version int
+		wantErr bool
+	}{
+		{"v0", string(v0), 0, false},
+		{"v0 after a summary", summary + "\n" + string(v0), 0, false},
+		{"v1", summary + "\n" + string(v1), 1, false},
+		{"v1 without a summary", string(v1), 1, false},
+		{"v2", summary + "\n" + string(v2), 2, false},
+		{"newer version", summary + "\n" + string(newer), SchemaVersion + 1, true},
+		{"malformed last line", string(v2) + "\n{", 0, true},
+		{"summary only", summary, 0, true},
+		{"trailing newline", string(v2) + "\n", 0, true},
+		{"empty", "", 0, true},
+	}
+	for _, tt := range tests {
+		got, version, err := DecodeBody(tt.body)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if version != tt.version || BodyVersion(tt.body) != tt.version {
+			t.Errorf("%s: version %d and BodyVersion %d, want %d", tt.name, version, BodyVersion(tt.body), tt.version)
+		}
+		if !tt.wantErr && got != ts {
+			t.Errorf("%s: decoded %+v, want %+v", tt.name, got, ts)
+		}
+	}
+}
+
+func TestEncodeBody(t *testing.T) {
+	ts := Transaction{A鄉鎮市區: "大安區", A交易年月日: 1497484800}
+	b, err := EncodeBody(ts, SchemaVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := Envelope{}
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != SchemaVersion {
+		t.Errorf("version %d, want %d", env.Version, SchemaVersion)
+	}
+	// Envelopes are single lines, which parseBody finds after the summary.
+	for _, c := range b {
+		if c == '\n' {
+			t.Fatalf("newline in %s", b)
+		}
+	}
+}
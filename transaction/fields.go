@@ -0,0 +1,90 @@
+package transaction
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fieldKeys are the JSON keys of the fields of Transaction in order.
+var fieldKeys = func() []string {
+	keys := []string{}
+	t := reflect.TypeOf(Transaction{})
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" {
+			key = t.Field(i).Name
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}()
+
+// Keys returns the JSON keys of the fields of Transaction in order, such as the header of CSV files.
+func Keys() []string {
+	return append([]string{}, fieldKeys...)
+}
+
+// EnglishKeys maps the JSON keys of Transaction to English, for tools that do not handle Chinese keys well.
+// Keys that are already English are not in the map.
+var EnglishKeys = map[string]string{
+	"鄉鎮市區":         "District",
+	"交易標的":         "Target",
+	"土地區段位置或建物區門牌": "Address",
+	"土地移轉總面積平方公尺":  "LandAreaM2",
+	"都市土地使用分區":     "UrbanZoning",
+	"非都市土地使用分區":    "NonUrbanZoning",
+	"非都市土地使用編定":    "NonUrbanLandUse",
+	"交易年月日":        "Date",
+	"交易筆棟數":        "Units",
+	"移轉層次":         "Floor",
+	"總樓層數":         "TotalFloors",
+	"建物型態":         "BuildingType",
+	"主要用途":         "MainUse",
+	"主要建材":         "MainMaterial",
+	"建築完成年月":       "CompletionDate",
+	"建物移轉總面積平方公尺":  "BuildingAreaM2",
+	"建物現況格局_房":     "Rooms",
+	"建物現況格局_廳":     "LivingRooms",
+	"建物現況格局_衛":     "Bathrooms",
+	"建物現況格局_隔間":    "Partitioned",
+	"有無管理組織":       "Managed",
+	"總價元":          "PriceNTD",
+	"單價每平方公尺":      "UnitPriceNTDPerM2",
+	"車位類別":         "ParkingType",
+	"車位移轉總面積平方公尺":  "ParkingAreaM2",
+	"車位總價元":        "ParkingPriceNTD",
+	"備註":           "Note",
+	"編號":           "ID",
+}
+
+// Values returns the fields of ts in the order of Keys.
+func (ts Transaction) Values() []interface{} {
+	v := reflect.ValueOf(ts)
+	vals := make([]interface{}, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		vals = append(vals, v.Field(i).Interface())
+	}
+	return vals
+}
+
+// Record returns the fields of ts as strings in the order of Keys, such as a row of CSV files.
+func (ts Transaction) Record() []string {
+	v := reflect.ValueOf(ts)
+	rec := make([]string, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			rec = append(rec, f.String())
+		case reflect.Int, reflect.Int64:
+			rec = append(rec, strconv.FormatInt(f.Int(), 10))
+		case reflect.Float64:
+			rec = append(rec, strconv.FormatFloat(f.Float(), 'f', -1, 64))
+		default:
+			panic(fmt.Sprintf("unsupported type %s of Transaction.%s", f.Type(), v.Type().Field(i).Name))
+		}
+	}
+	return rec
+}
@@ -1,5 +1,31 @@
 package transaction
 
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Qualities of the location of a Transaction.
+const (
+	// LocationGeocoded means Lat and Lng are the geocoded address.
+	LocationGeocoded = ""
+	// LocationCentroid means the address could not be geocoded,
+	// and Lat and Lng are the centroid of 鄉鎮市區.
+	LocationCentroid = "centroid"
+)
+
+// Labels of the location of a Transaction, which unlike its LocationQuality tell missing locations apart.
+const (
+	LabelGeocoded = "geocoded"
+	LabelCentroid = "centroid"
+	LabelMissing  = "missing"
+)
+
 type Transaction struct {
 	A鄉鎮市區         string  `json:"鄉鎮市區,omitempty"`
 	A交易標的         string  `json:"交易標的,omitempty"`
@@ -32,4 +58,64 @@ type Transaction struct {
 
 	Lat float64 `json:",omitempty"`
 	Lng float64 `json:",omitempty"`
+
+	LocationQuality string `json:",omitempty"`
+
+	// VillageCode and VillageName identify the 村里 containing Lat and Lng.
+	VillageCode string `json:",omitempty"`
+	VillageName string `json:",omitempty"`
+	// StatAreaCode is the code of the 最小統計區 containing Lat and Lng.
+	StatAreaCode string `json:",omitempty"`
+
+	// CountyCode is the letter of the county in the names of 實價登錄 files, such as A for 臺北市.
+	// Unlike the address, it is known for land 區段 too.
+	CountyCode string `json:",omitempty"`
+}
+
+// LocationLabel returns the label of the location of ts.
+func (ts Transaction) LocationLabel() string {
+	switch {
+	case ts.Lat == 0 && ts.Lng == 0:
+		return LabelMissing
+	case ts.LocationQuality == LocationCentroid:
+		return LabelCentroid
+	}
+	return LabelGeocoded
+}
+
+// ScanFile calls fn for every transaction in fname, a file written by cmd/parse.
+func ScanFile(fname string, fn func(line int, ts Transaction) error) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return errors.Wrap(err, "os.Open")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for i := 0; scanner.Scan(); i++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		ts := Transaction{}
+		if err := json.Unmarshal(scanner.Bytes(), &ts); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("json.Unmarshal line %d", i))
+		}
+		if err := fn(i, ts); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "scanner.Err")
+	}
+	return nil
+}
+
+// Season returns the ROC year and quarter of a 交易年月日, such as 109年第3季.
+func Season(date int64) string {
+	if date <= 0 {
+		return ""
+	}
+	tm := time.Unix(date, 0).UTC()
+	return fmt.Sprintf("%d年第%d季", tm.Year()-1911, (int(tm.Month())-1)/3+1)
 }
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// LoadCredential returns the secret in the file fname, the environment variable env, or value, whichever is set first.
+// value, usually a command line flag, is the fallback, since flags leak into shell history and ps.
+// fname must not be accessible by group or others. The empty string is returned if none is set.
+func LoadCredential(env, fname, value string) (string, error) {
+	if fname != "" {
+		info, err := os.Stat(fname)
+		if err != nil {
+			return "", errors.Wrap(err, "os.Stat")
+		}
+		if perm := info.Mode().Perm(); perm&0077 != 0 {
+			return "", fmt.Errorf("%s has permissions %v, which should be 0600 or stricter", fname, perm)
+		}
+		b, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return "", errors.Wrap(err, "ioutil.ReadFile")
+		}
+		secret := strings.TrimSpace(string(b))
+		if secret == "" {
+			return "", fmt.Errorf("%s is empty", fname)
+		}
+		return secret, nil
+	}
+	if secret := os.Getenv(env); secret != "" {
+		return secret, nil
+	}
+	if value != "" {
+		glog.Warningf("secrets in flags are visible in shell history and ps, use $%s or a file instead", env)
+	}
+	return value, nil
+}
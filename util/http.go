@@ -1,38 +1,72 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
 
+var (
+	// DefaultClient is the client used by the JSONReq functions that do not take one.
+	DefaultClient = &http.Client{}
+	// Timeout bounds each request whose context has no deadline. Zero means no limit.
+	Timeout = 60 * time.Second
+	// MaxResponseBytes limits the size of response bodies. Zero means no limit.
+	MaxResponseBytes int64 = 32 << 20
+	// UserAgent is sent with requests that do not set their own User-Agent header.
+	UserAgent = "housing"
+)
+
 func JSONReq3(method, urlStr string, res interface{}) (*http.Response, []byte, error) {
-	return JSONReq6(method, urlStr, nil, nil, http.DefaultClient, res)
+	return JSONReq6Context(context.Background(), method, urlStr, nil, nil, DefaultClient, res)
 }
 
 func JSONReq5(method, urlStr string, body io.Reader, header http.Header, res interface{}) (*http.Response, []byte, error) {
-	return JSONReq6(method, urlStr, body, header, http.DefaultClient, res)
+	return JSONReq6Context(context.Background(), method, urlStr, body, header, DefaultClient, res)
 }
 
 func JSONReq6(method, urlStr string, body io.Reader, header http.Header, c *http.Client, res interface{}) (*http.Response, []byte, error) {
+	return JSONReq6Context(context.Background(), method, urlStr, body, header, c, res)
+}
+
+func JSONReq3Context(ctx context.Context, method, urlStr string, res interface{}) (*http.Response, []byte, error) {
+	return JSONReq6Context(ctx, method, urlStr, nil, nil, DefaultClient, res)
+}
+
+func JSONReq5Context(ctx context.Context, method, urlStr string, body io.Reader, header http.Header, res interface{}) (*http.Response, []byte, error) {
+	return JSONReq6Context(ctx, method, urlStr, body, header, DefaultClient, res)
+}
+
+func JSONReq6Context(ctx context.Context, method, urlStr string, body io.Reader, header http.Header, c *http.Client, res interface{}) (*http.Response, []byte, error) {
+	if _, ok := ctx.Deadline(); !ok && Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, Timeout)
+		defer cancel()
+	}
 	req, err := http.NewRequest(method, urlStr, body)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "NewRequest")
 	}
+	req = req.WithContext(ctx)
 	for k, v := range header {
 		req.Header[k] = v
 	}
+	if req.Header.Get("User-Agent") == "" && UserAgent != "" {
+		req.Header.Set("User-Agent", UserAgent)
+	}
 	resp, err := c.Do(req)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "RequestDo")
 	}
 	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
+	b, err := readBody(resp.Body)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "ReadAll")
 	}
@@ -47,3 +81,17 @@ func JSONReq6(method, urlStr string, body io.Reader, header http.Header, c *http
 	}
 	return resp, b, nil
 }
+
+func readBody(r io.Reader) ([]byte, error) {
+	if MaxResponseBytes <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > MaxResponseBytes {
+		return nil, fmt.Errorf("response body exceeds %d bytes", MaxResponseBytes)
+	}
+	return b, nil
+}
@@ -0,0 +1,265 @@
+package jinma
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"housing/util"
+)
+
+const (
+	DefaultBaseURL = "http://www.jinma.io"
+	// TokenEnv is the environment variable of the token.
+	TokenEnv = "JINMA_TOKEN"
+)
+
+// LoadToken returns the token in fname, $JINMA_TOKEN or value, see util.LoadCredential.
+func LoadToken(fname, value string) (string, error) {
+	token, err := util.LoadCredential(TokenEnv, fname, value)
+	if err != nil {
+		return "", errors.Wrap(err, "util.LoadCredential")
+	}
+	if token == "" {
+		return "", fmt.Errorf("no Jinma token, set $%s, a token file or the flag", TokenEnv)
+	}
+	return token, nil
+}
+
+// Client calls the Jinma API on behalf of the user of Token.
+// Middleware such as authentication or tracing can be installed in the Transport of HTTPClient.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Token      string
+	// TokenInHeader sends Token in the Authorization header instead of the request parameters,
+	// which keeps it out of the URLs of GET requests, and so out of the logs of proxies and servers.
+	// It is set by NewClient unless disabled with WithTokenInHeader(false).
+	TokenInHeader bool
+	// Header is added to every request.
+	Header http.Header
+	// RetryPolicy is used to retry the idempotent calls, all calls but MsgCreate.
+	RetryPolicy util.RetryPolicy
+	// Partitions is the number of partitions scanned by ScanAllPartitions.
+	Partitions int
+}
+
+type Option func(*Client)
+
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.BaseURL = baseURL
+	}
+}
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = hc
+	}
+}
+
+func WithTokenInHeader(inHeader bool) Option {
+	return func(c *Client) {
+		c.TokenInHeader = inHeader
+	}
+}
+
+func WithRetryPolicy(p util.RetryPolicy) Option {
+	return func(c *Client) {
+		c.RetryPolicy = p
+	}
+}
+
+func WithPartitions(n int) Option {
+	return func(c *Client) {
+		c.Partitions = n
+	}
+}
+
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.Header.Add(key, value)
+	}
+}
+
+func NewClient(token string, opts ...Option) *Client {
+	c := Client{
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: util.DefaultClient,
+		Token:      token,
+		Header:     make(http.Header),
+
+		TokenInHeader: true,
+
+		RetryPolicy: util.DefaultRetryPolicy,
+		Partitions:  DefaultPartitions,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// call sends vals in the query string of GET requests, and form-encoded in the body of other requests.
+// The token is redacted from the returned errors.
+func (c *Client) call(ctx context.Context, method, path string, vals url.Values, res interface{}) error {
+	header := make(http.Header)
+	for k, v := range c.Header {
+		header[k] = v
+	}
+	if c.TokenInHeader {
+		header.Set("Authorization", "Bearer "+c.Token)
+	} else {
+		vals.Set("Token", c.Token)
+	}
+
+	urlStr := c.BaseURL + path
+	var body io.Reader
+	if method == "GET" {
+		urlStr += "?" + vals.Encode()
+	} else {
+		body = strings.NewReader(vals.Encode())
+		header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	// The body is decoded only after the status is checked, since error responses are not always JSON.
+	httpResp, respBody, err := util.JSONReq6Context(ctx, method, urlStr, body, header, c.HTTPClient, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "util.JSONReq6Context")
+		}
+		return util.RedactError(errors.Wrap(err, "util.JSONReq6Context"), c.Token)
+	}
+	if httpResp.StatusCode != 200 {
+		apiErr := newAPIError(httpResp.StatusCode, respBody)
+		apiErr.Message = util.Redact(apiErr.Message, c.Token)
+		return apiErr
+	}
+	if res == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, res); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("json.Unmarshal %s", util.Redact(string(respBody), c.Token)))
+	}
+	return nil
+}
+
+// retry calls f until it returns a non-retryable error, making c.RetryPolicy.Attempts attempts at most.
+// f is given the attempt, counting from 0.
+func (c *Client) retry(ctx context.Context, path string, f func(attempt int) error) error {
+	var err error
+	attempts := c.RetryPolicy.Attempts()
+	for i := 0; i < attempts; i++ {
+		err = f(i)
+		if !IsRetryable(err) {
+			return err
+		}
+		if i < attempts-1 {
+			glog.Warningf("attempt %d of %s: %v", i, path, err)
+			if sleepErr := c.RetryPolicy.Sleep(ctx, i); sleepErr != nil {
+				return errors.Wrap(sleepErr, path)
+			}
+		}
+	}
+	return err
+}
+
+// callWithRetry retries call on retryable errors according to c.RetryPolicy.
+func (c *Client) callWithRetry(ctx context.Context, method, path string, vals url.Values, res interface{}) error {
+	return c.retry(ctx, path, func(int) error {
+		return c.call(ctx, method, path, vals, res)
+	})
+}
+
+func (c *Client) Me(ctx context.Context) (*MeResp, error) {
+	vals := url.Values{}
+	resp := MeResp{}
+	if err := c.callWithRetry(ctx, "POST", "/Me", vals, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) MsgCreate(ctx context.Context, body string, lat, lng float64, skf64 *float64, hashtags []string, customID string) (*Msg, error) {
+	vals := url.Values{
+		"Lat":  {strconv.FormatFloat(lat, 'f', -1, 64)},
+		"Lng":  {strconv.FormatFloat(lng, 'f', -1, 64)},
+		"Body": {body},
+	}
+	if skf64 != nil {
+		vals.Set("SKF64", strconv.FormatFloat(*skf64, 'f', -1, 64))
+	}
+	if len(hashtags) > 0 {
+		vals["Hashtags"] = hashtags
+	}
+	if customID != "" {
+		vals.Set("CustomID", customID)
+	}
+	resp := Msg{}
+	if err := c.call(ctx, "POST", "/MsgCreate", vals, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// MsgUpdate updates the body, sort key and hashtags of message id.
+// An empty body, a nil skf64 and nil hashtags are left unchanged, and empty non-nil hashtags remove all hashtags.
+func (c *Client) MsgUpdate(ctx context.Context, id string, body []byte, skf64 *float64, hashtags []string) (*Msg, error) {
+	vals := url.Values{
+		"MsgID": {id},
+	}
+	if len(body) > 0 {
+		vals.Set("Body", string(body))
+	}
+	if skf64 != nil {
+		vals.Set("SKF64", strconv.FormatFloat(*skf64, 'f', -1, 64))
+	}
+	if hashtags != nil {
+		vals["Hashtags"] = hashtags
+		if len(hashtags) == 0 {
+			vals["Hashtags"] = []string{""}
+		}
+	}
+	resp := Msg{}
+	if err := c.callWithRetry(ctx, "POST", "/MsgUpdate", vals, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// MsgDelete deletes message id.
+// A retried deletion of a message that is not found succeeds, since an earlier attempt may have deleted it before its response was lost.
+func (c *Client) MsgDelete(ctx context.Context, id string) error {
+	vals := url.Values{
+		"MsgID": {id},
+	}
+	return c.retry(ctx, "/MsgDelete", func(attempt int) error {
+		err := c.call(ctx, "POST", "/MsgDelete", vals, nil)
+		if attempt > 0 && IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (c *Client) MsgsByAppUser(ctx context.Context, appID string, partition int, esk string) (*MsgsByAppUserResp, error) {
+	vals := url.Values{
+		"AppID": {appID},
+		"I":     {fmt.Sprintf("%d", partition)},
+	}
+	if esk != "" {
+		vals.Set("ESK", esk)
+	}
+	resp := MsgsByAppUserResp{}
+	if err := c.callWithRetry(ctx, "GET", "/MsgsByAppUser", vals, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
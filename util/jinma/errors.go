@@ -0,0 +1,99 @@
+package jinma
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// APIError is a non-200 response of the Jinma API.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("request error: %d %s %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("request error: %d %s", e.StatusCode, e.Message)
+}
+
+func newAPIError(statusCode int, body []byte) *APIError {
+	e := APIError{StatusCode: statusCode, Message: string(body)}
+	parsed := struct {
+		Code    string
+		Message string
+	}{}
+	if err := json.Unmarshal(body, &parsed); err == nil && (parsed.Code != "" || parsed.Message != "") {
+		e.Code = parsed.Code
+		e.Message = parsed.Message
+	}
+	return &e
+}
+
+func apiError(err error) (*APIError, bool) {
+	e, ok := errors.Cause(err).(*APIError)
+	return e, ok
+}
+
+func IsUnauthorized(err error) bool {
+	e, ok := apiError(err)
+	return ok && (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
+
+func IsRateLimited(err error) bool {
+	e, ok := apiError(err)
+	return ok && e.StatusCode == http.StatusTooManyRequests
+}
+
+func IsNotFound(err error) bool {
+	e, ok := apiError(err)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// IsRetryable reports whether a request that failed with err may succeed when retried.
+// Rate limits, server errors and transport errors, such as a refused connection or a truncated response, are retryable.
+// Other errors, such as undecodable responses, are not.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errors.Cause(err)
+	if cause == context.Canceled || cause == context.DeadlineExceeded {
+		return false
+	}
+	if e, ok := cause.(*APIError); ok {
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	}
+	if cause == io.ErrUnexpectedEOF {
+		return true
+	}
+	switch cause.(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+	return false
+}
+
+// IsNotSent reports whether a request that failed with err was certainly not processed by Jinma,
+// because it was rate limited or the connection could not be made.
+// Only such failures of requests that are not idempotent, such as MsgCreate, may be retried.
+func IsNotSent(err error) bool {
+	if IsRateLimited(err) {
+		return true
+	}
+	cause := errors.Cause(err)
+	if e, ok := cause.(*url.Error); ok {
+		cause = e.Err
+	}
+	e, ok := cause.(*net.OpError)
+	return ok && e.Op == "dial"
+}
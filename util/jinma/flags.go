@@ -0,0 +1,35 @@
+package jinma
+
+import (
+	"flag"
+
+	"github.com/pkg/errors"
+)
+
+// Flags are the command line flags of the commands that call Jinma.
+type Flags struct {
+	TokenFile     string
+	Token         string
+	BaseURL       string
+	TokenInHeader bool
+}
+
+// RegisterFlags registers the Jinma flags in fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.TokenFile, "jinmaTokenFile", "", "file containing the Jinma user token, which must not be readable by others")
+	fs.StringVar(&f.Token, "jinmaToken", "", "Jinma user token, prefer $"+TokenEnv+" or jinmaTokenFile")
+	fs.StringVar(&f.BaseURL, "jinmaBaseURL", DefaultBaseURL, "base URL of the Jinma API")
+	fs.BoolVar(&f.TokenInHeader, "jinmaTokenInHeader", true, "send the Jinma token in the Authorization header, which keeps it out of URLs, or in the request parameters if false")
+	return f
+}
+
+// Client loads the token of f and returns a client configured by f, and then by opts.
+func (f *Flags) Client(opts ...Option) (*Client, error) {
+	token, err := LoadToken(f.TokenFile, f.Token)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadToken")
+	}
+	opts = append([]Option{WithBaseURL(f.BaseURL), WithTokenInHeader(f.TokenInHeader)}, opts...)
+	return NewClient(token, opts...), nil
+}
@@ -1,18 +1,7 @@
 package jinma
 
 import (
-	"fmt"
-	"net/url"
-	"strconv"
-
-	"github.com/golang/glog"
-	"github.com/pkg/errors"
-
-	"housing/util"
-)
-
-const (
-	host = "http://www.jinma.io"
+	"context"
 )
 
 type App struct {
@@ -54,88 +43,36 @@ type MeResp struct {
 	App  App
 }
 
+// The functions below use a Client with the default options.
+
 func Me(token string) (*MeResp, error) {
-	vals := url.Values{
-		"Token": {token},
-	}
-	urlStr := host + "/Me?" + vals.Encode()
-	resp := MeResp{}
-	httpResp, respBody, err := util.JSONReq3("POST", urlStr, &resp)
-	if err != nil {
-		return nil, errors.Wrap(err, "util.JSONReq3")
-	}
-	if httpResp.StatusCode != 200 {
-		return nil, fmt.Errorf("request error: %d %s", httpResp.StatusCode, respBody)
-	}
-	return &resp, nil
+	return NewClient(token).Me(context.Background())
+}
+
+func MeContext(ctx context.Context, token string) (*MeResp, error) {
+	return NewClient(token).Me(ctx)
 }
 
 func MsgCreate(token, body string, lat, lng float64, skf64 *float64, customID string) (*Msg, error) {
-	vals := url.Values{
-		"Lat":   {strconv.FormatFloat(lat, 'f', -1, 64)},
-		"Lng":   {strconv.FormatFloat(lng, 'f', -1, 64)},
-		"Body":  {body},
-		"Token": {token},
-	}
-	if skf64 != nil {
-		vals.Set("SKF64", strconv.FormatFloat(*skf64, 'f', -1, 64))
-	}
-	if customID != "" {
-		vals.Set("CustomID", customID)
-	}
-	urlStr := host + "/MsgCreate?" + vals.Encode()
-	resp := Msg{}
-	httpResp, respBody, err := util.JSONReq3("POST", urlStr, &resp)
-	if err != nil {
-		return nil, errors.Wrap(err, "util.JSONReq3")
-	}
-	if httpResp.StatusCode != 200 {
-		return nil, fmt.Errorf("request error: %d %s", httpResp.StatusCode, respBody)
-	}
-	return &resp, nil
+	return NewClient(token).MsgCreate(context.Background(), body, lat, lng, skf64, nil, customID)
+}
+
+func MsgCreateContext(ctx context.Context, token, body string, lat, lng float64, skf64 *float64, customID string) (*Msg, error) {
+	return NewClient(token).MsgCreate(ctx, body, lat, lng, skf64, nil, customID)
 }
 
 func MsgUpdate(id, token string, body []byte, skf64 *float64) (*Msg, error) {
-	vals := url.Values{
-		"MsgID": {id},
-		"Token": {token},
-	}
-	if len(body) > 0 {
-		vals.Set("Body", string(body))
-	}
-	if skf64 != nil {
-		vals.Set("SKF64", strconv.FormatFloat(*skf64, 'f', -1, 64))
-	}
-	urlStr := host + "/MsgUpdate?" + vals.Encode()
-	resp := Msg{}
-	httpResp, respBody, err := util.JSONReq3("POST", urlStr, &resp)
-	if err != nil {
-		glog.Errorf("%+v", err)
-		return nil, errors.Wrap(err, "util.JSONReq3")
-	}
-	if httpResp.StatusCode != 200 {
-		return nil, fmt.Errorf("request error: %d %s", httpResp.StatusCode, respBody)
-	}
-	return &resp, nil
+	return NewClient(token).MsgUpdate(context.Background(), id, body, skf64, nil)
+}
+
+func MsgUpdateContext(ctx context.Context, id, token string, body []byte, skf64 *float64) (*Msg, error) {
+	return NewClient(token).MsgUpdate(ctx, id, body, skf64, nil)
 }
 
 func MsgsByAppUser(appID, token string, partition int, esk string) (*MsgsByAppUserResp, error) {
-	vals := url.Values{
-		"AppID": {appID},
-		"Token": {token},
-		"I":     {fmt.Sprintf("%d", partition)},
-	}
-	if esk != "" {
-		vals.Set("ESK", esk)
-	}
-	urlStr := host + "/MsgsByAppUser?" + vals.Encode()
-	resp := MsgsByAppUserResp{}
-	httpResp, respBody, err := util.JSONReq3("GET", urlStr, &resp)
-	if err != nil {
-		return nil, errors.Wrap(err, "util.JSONReq3")
-	}
-	if httpResp.StatusCode != 200 {
-		return nil, fmt.Errorf("request error: %d %s", httpResp.StatusCode, respBody)
-	}
-	return &resp, nil
+	return NewClient(token).MsgsByAppUser(context.Background(), appID, partition, esk)
+}
+
+func MsgsByAppUserContext(ctx context.Context, appID, token string, partition int, esk string) (*MsgsByAppUserResp, error) {
+	return NewClient(token).MsgsByAppUser(ctx, appID, partition, esk)
 }
@@ -0,0 +1,392 @@
+// Package jinmatest provides an in-memory fake Jinma server for tests.
+package jinmatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"housing/util/jinma"
+)
+
+const (
+	DefaultPartitions = 1536
+	DefaultPageSize   = 100
+)
+
+// Error codes returned by the server.
+const (
+	CodeInvalidToken   = "InvalidToken"
+	CodeInvalidRequest = "InvalidRequest"
+	CodeNotFound       = "NotFound"
+	CodeCustomIDExists = "CustomIDExists"
+	CodeInjectedFault  = "InjectedFault"
+)
+
+type identity struct {
+	user jinma.User
+	app  jinma.App
+}
+
+type fault struct {
+	path   string
+	status int
+}
+
+// Server is a fake Jinma server. Messages are scoped to the app and user of the token that created them.
+type Server struct {
+	*httptest.Server
+
+	// Partitions is the number of partitions that messages are spread over.
+	Partitions int
+	// PageSize is the maximum number of messages returned by a MsgsByAppUser call.
+	PageSize int
+
+	mu        sync.Mutex
+	users     map[string]identity
+	msgs      map[string]*jinma.Msg
+	customIDs map[string]string
+	nextID    int
+
+	latency              time.Duration
+	faults               []fault
+	dropCreateResponses  int
+	allowDuplicateCustom bool
+}
+
+// NewServer starts a fake Jinma server. Callers should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		Partitions: DefaultPartitions,
+		PageSize:   DefaultPageSize,
+		users:      make(map[string]identity),
+		msgs:       make(map[string]*jinma.Msg),
+		customIDs:  make(map[string]string),
+
+		allowDuplicateCustom: true,
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// AddUser registers token as the token of user in app.
+func (s *Server) AddUser(token string, user jinma.User, app jinma.App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[token] = identity{user: user, app: app}
+}
+
+// Client returns a jinma.Client of token that talks to s.
+func (s *Server) Client(token string, opts ...jinma.Option) *jinma.Client {
+	opts = append([]jinma.Option{jinma.WithBaseURL(s.URL), jinma.WithHTTPClient(s.Server.Client())}, opts...)
+	return jinma.NewClient(token, opts...)
+}
+
+// Msgs returns a copy of all stored messages ordered by ID.
+func (s *Server) Msgs() []jinma.Msg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := make([]jinma.Msg, 0, len(s.msgs))
+	for _, m := range s.msgs {
+		msgs = append(msgs, *m)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+	return msgs
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// FailNext makes the next n requests to path, or to any path if path is empty,
+// fail with status without being processed.
+func (s *Server) FailNext(path string, n int, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.faults = append(s.faults, fault{path: path, status: status})
+	}
+}
+
+// DropCreateResponses makes the next n MsgCreate requests store their message
+// but respond with 500, as if the response was lost, so that retries create duplicates.
+func (s *Server) DropCreateResponses(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropCreateResponses = n
+}
+
+// AllowDuplicateCustomIDs sets whether a message may have the CustomID of another message of its app.
+// Jinma allows it, and so does Server unless it is disabled.
+func (s *Server) AllowDuplicateCustomIDs(allow bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allowDuplicateCustom = allow
+}
+
+func (s *Server) partition(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(s.Partitions))
+}
+
+func customIDKey(appID, customID string) string {
+	return appID + "/" + customID
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, format string, a ...interface{}) {
+	writeJSON(w, status, struct {
+		Code    string
+		Message string
+	}{Code: code, Message: fmt.Sprintf(format, a...)})
+}
+
+// takeFault pops the first injected fault matching path.
+func (s *Server) takeFault(path string) *fault {
+	for i, f := range s.faults {
+		if f.path == "" || f.path == path {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			return &f
+		}
+	}
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	latency := s.latency
+	f := s.takeFault(r.URL.Path)
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if f != nil {
+		writeError(w, f.status, CodeInjectedFault, "injected fault")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.users[tokenOf(r)]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, CodeInvalidToken, "invalid token")
+		return
+	}
+	switch r.URL.Path {
+	case "/Me":
+		writeJSON(w, http.StatusOK, jinma.MeResp{User: id.user, App: id.app})
+	case "/MsgCreate":
+		s.msgCreate(w, r, id)
+	case "/MsgUpdate":
+		s.msgUpdate(w, r, id)
+	case "/MsgDelete":
+		s.msgDelete(w, r, id)
+	case "/MsgsByAppUser":
+		s.msgsByAppUser(w, r, id)
+	default:
+		writeError(w, http.StatusNotFound, CodeNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+func tokenOf(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Form.Get("Token")
+}
+
+func parseFloat(r *http.Request, key string) (*float64, error) {
+	v := r.Form.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %s", key, v)
+	}
+	return &f, nil
+}
+
+// hashtagsOf returns the non-empty Hashtags of r.
+func hashtagsOf(r *http.Request) []string {
+	var tags []string
+	for _, tag := range r.Form["Hashtags"] {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (s *Server) msgCreate(w http.ResponseWriter, r *http.Request, id identity) {
+	lat, err := parseFloat(r, "Lat")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	lng, err := parseFloat(r, "Lng")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	skf64, err := parseFloat(r, "SKF64")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	if lat == nil || lng == nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "missing Lat or Lng")
+		return
+	}
+
+	customID := r.Form.Get("CustomID")
+	key := customIDKey(id.app.ID, customID)
+	if customID != "" && !s.allowDuplicateCustom {
+		if _, ok := s.customIDs[key]; ok {
+			writeError(w, http.StatusConflict, CodeCustomIDExists, "CustomID %s exists", customID)
+			return
+		}
+	}
+
+	now := float64(time.Now().UnixNano()) / 1e9
+	s.nextID++
+	msg := &jinma.Msg{
+		ID:       fmt.Sprintf("msg%08d", s.nextID),
+		User:     id.user,
+		Time:     now,
+		Body:     r.Form.Get("Body"),
+		Lat:      *lat,
+		Lng:      *lng,
+		SKF64:    now,
+		Hashtags: hashtagsOf(r),
+		App:      id.app,
+		CustomID: customID,
+	}
+	if skf64 != nil {
+		msg.SKF64 = *skf64
+	}
+	s.msgs[msg.ID] = msg
+	if customID != "" {
+		s.customIDs[key] = msg.ID
+	}
+
+	if s.dropCreateResponses > 0 {
+		s.dropCreateResponses--
+		writeError(w, http.StatusInternalServerError, CodeInjectedFault, "dropped response")
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (s *Server) msgUpdate(w http.ResponseWriter, r *http.Request, id identity) {
+	msg, ok := s.msgs[r.Form.Get("MsgID")]
+	if !ok || msg.App.ID != id.app.ID || msg.User.ID != id.user.ID {
+		writeError(w, http.StatusNotFound, CodeNotFound, "message %s not found", r.Form.Get("MsgID"))
+		return
+	}
+	skf64, err := parseFloat(r, "SKF64")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+
+	if _, ok := r.Form["Body"]; ok {
+		msg.Body = r.Form.Get("Body")
+	}
+	if skf64 != nil {
+		msg.SKF64 = *skf64
+	}
+	if _, ok := r.Form["Hashtags"]; ok {
+		msg.Hashtags = hashtagsOf(r)
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (s *Server) msgDelete(w http.ResponseWriter, r *http.Request, id identity) {
+	msg, ok := s.msgs[r.Form.Get("MsgID")]
+	if !ok || msg.App.ID != id.app.ID || msg.User.ID != id.user.ID {
+		writeError(w, http.StatusNotFound, CodeNotFound, "message %s not found", r.Form.Get("MsgID"))
+		return
+	}
+	delete(s.msgs, msg.ID)
+	key := customIDKey(msg.App.ID, msg.CustomID)
+	if s.customIDs[key] == msg.ID {
+		delete(s.customIDs, key)
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) msgsByAppUser(w http.ResponseWriter, r *http.Request, id identity) {
+	if appID := r.Form.Get("AppID"); appID != id.app.ID {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "AppID %s not of token", appID)
+		return
+	}
+	partition, err := strconv.Atoi(r.Form.Get("I"))
+	if err != nil || partition < 0 || partition >= s.Partitions {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid partition %s", r.Form.Get("I"))
+		return
+	}
+
+	msgs := []jinma.Msg{}
+	for _, m := range s.msgs {
+		if m.App.ID == id.app.ID && m.User.ID == id.user.ID && s.partition(m.ID) == partition {
+			msgs = append(msgs, *m)
+		}
+	}
+	// Messages are sorted by SKF64 in descending order, and then by ID.
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].SKF64 != msgs[j].SKF64 {
+			return msgs[i].SKF64 > msgs[j].SKF64
+		}
+		return msgs[i].ID < msgs[j].ID
+	})
+
+	start := 0
+	if esk := r.Form.Get("ESK"); esk != "" {
+		start = -1
+		for i, m := range msgs {
+			if m.ID == esk {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid ESK %s", esk)
+			return
+		}
+	}
+	end := start + s.PageSize
+	resp := jinma.MsgsByAppUserResp{}
+	if end < len(msgs) {
+		resp.LastEvaluatedKey = msgs[end-1].ID
+	} else {
+		end = len(msgs)
+	}
+	resp.Msgs = msgs[start:end]
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,404 @@
+package jinmatest_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"housing/util"
+	"housing/util/jinma"
+	"housing/util/jinma/jinmatest"
+)
+
+const testToken = "test-token"
+
+func newServer(t *testing.T) (*jinmatest.Server, *jinma.Client) {
+	s := jinmatest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddUser(testToken, jinma.User{ID: "user"}, jinma.App{ID: "app"})
+	return s, s.Client(testToken)
+}
+
+// scan returns the IDs of the messages in partition, following LastEvaluatedKey across pages.
+func scan(t *testing.T, c *jinma.Client, partition int) []string {
+	ids := []string{}
+	esk := ""
+	for {
+		resp, err := c.MsgsByAppUser(context.Background(), "app", partition, esk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range resp.Msgs {
+			ids = append(ids, msg.ID)
+		}
+		if resp.LastEvaluatedKey == "" {
+			return ids
+		}
+		esk = resp.LastEvaluatedKey
+	}
+}
+
+func TestMe(t *testing.T) {
+	s, c := newServer(t)
+	me, err := c.Me(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me.User.ID != "user" || me.App.ID != "app" {
+		t.Errorf("got %+v", me)
+	}
+
+	if _, err := s.Client("unknown").Me(context.Background()); err == nil {
+		t.Errorf("no error of unknown token")
+	}
+}
+
+// urlRecorder records the URLs of the requests it sends.
+type urlRecorder struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+func (ur *urlRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	ur.mu.Lock()
+	ur.urls = append(ur.urls, r.URL.String())
+	ur.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestToken(t *testing.T) {
+	for _, inHeader := range []bool{true, false} {
+		s, _ := newServer(t)
+		ur := &urlRecorder{}
+		opts := []jinma.Option{jinma.WithHTTPClient(&http.Client{Transport: ur})}
+		// The token is in the header by default.
+		if !inHeader {
+			opts = append(opts, jinma.WithTokenInHeader(false))
+		}
+		c := s.Client(testToken, opts...)
+		ctx := context.Background()
+		if _, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, "RPTEST0001"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.MsgsByAppUser(ctx, "app", 0, ""); err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range ur.urls {
+			// Only GET requests send the token in the URL, unless it is in the header.
+			if in := strings.Contains(u, testToken); in != (!inHeader && strings.Contains(u, "/MsgsByAppUser")) {
+				t.Errorf("token in URL %s is %v with token in header %v", u, in, inHeader)
+			}
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	s, _ := newServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte(testToken+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{}, {"-jinmaTokenInHeader=false"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := jinma.RegisterFlags(fs)
+		if err := fs.Parse(append([]string{"-jinmaTokenFile", tokenFile, "-jinmaBaseURL", s.URL}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		c, err := f.Client(jinma.WithPartitions(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.TokenInHeader != (len(args) == 0) || c.Partitions != 3 {
+			t.Errorf("client of %v has TokenInHeader %v and %d partitions", args, c.TokenInHeader, c.Partitions)
+		}
+		if _, err := c.Me(context.Background()); err != nil {
+			t.Errorf("Me with %v: %v", args, err)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := jinma.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-jinmaTokenFile", filepath.Join(t.TempDir(), "missing")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Client(); err == nil {
+		t.Errorf("no error of missing token file")
+	}
+}
+
+func TestMsgCreateUpdate(t *testing.T) {
+	s, c := newServer(t)
+	ctx := context.Background()
+	skf64 := 1.5
+	msg, err := c.MsgCreate(ctx, "body", 25.03, 121.56, &skf64, nil, "RPTEST0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Body != "body" || msg.Lat != 25.03 || msg.Lng != 121.56 || msg.SKF64 != skf64 || msg.CustomID != "RPTEST0001" {
+		t.Errorf("created %+v", msg)
+	}
+
+	skf64 = 2.5
+	if _, err := c.MsgUpdate(ctx, msg.ID, []byte("new body"), &skf64, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Updates without a body keep the body.
+	if _, err := c.MsgUpdate(ctx, msg.ID, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	msgs := s.Msgs()
+	if len(msgs) != 1 || msgs[0].Body != "new body" || msgs[0].SKF64 != skf64 {
+		t.Errorf("stored %+v", msgs)
+	}
+
+	if _, err := c.MsgUpdate(ctx, "unknown", nil, &skf64, nil); err == nil {
+		t.Errorf("no error of unknown message")
+	}
+}
+
+func TestMsgDelete(t *testing.T) {
+	s, c := newServer(t)
+	ctx := context.Background()
+	msg, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, "RPTEST0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddUser("other-token", jinma.User{ID: "other"}, jinma.App{ID: "app"})
+	if err := s.Client("other-token").MsgDelete(ctx, msg.ID); err == nil {
+		t.Errorf("no error of deleting the message of another user")
+	}
+	if err := c.MsgDelete(ctx, msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Msgs()); n != 0 {
+		t.Errorf("%d messages after delete", n)
+	}
+	if err := c.MsgDelete(ctx, msg.ID); err == nil {
+		t.Errorf("no error of deleting a deleted message")
+	}
+	// The CustomID of a deleted message can be reused.
+	if _, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, "RPTEST0001"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashtags(t *testing.T) {
+	s, c := newServer(t)
+	ctx := context.Background()
+	msg, err := c.MsgCreate(ctx, "body", 25, 121, nil, []string{"信義區", "公寓"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(want []string) {
+		t.Helper()
+		if got := s.Msgs()[0].Hashtags; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("hashtags %v, want %v", got, want)
+		}
+	}
+	check([]string{"信義區", "公寓"})
+
+	// Nil hashtags are left unchanged, and empty ones remove all hashtags.
+	if _, err := c.MsgUpdate(ctx, msg.ID, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"信義區", "公寓"})
+	if _, err := c.MsgUpdate(ctx, msg.ID, nil, nil, []string{"大安區"}); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"大安區"})
+	if _, err := c.MsgUpdate(ctx, msg.ID, nil, nil, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	check(nil)
+}
+
+func TestMsgsByAppUser(t *testing.T) {
+	s, c := newServer(t)
+	s.Partitions = 3
+	s.PageSize = 2
+	ctx := context.Background()
+	for i := 0; i < 11; i++ {
+		skf64 := float64(i % 4)
+		if _, err := c.MsgCreate(ctx, "body", 25, 121, &skf64, nil, fmt.Sprintf("RPTEST%04d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Messages of other users are not scanned.
+	s.AddUser("other-token", jinma.User{ID: "other"}, jinma.App{ID: "app"})
+	if _, err := s.Client("other-token").MsgCreate(ctx, "body", 25, 121, nil, nil, "OTHER0000"); err != nil {
+		t.Fatal(err)
+	}
+
+	byID := make(map[string]jinma.Msg)
+	for _, msg := range s.Msgs() {
+		byID[msg.ID] = msg
+	}
+	got := []string{}
+	for p := 0; p < s.Partitions; p++ {
+		ids := scan(t, c, p)
+		for i := 1; i < len(ids); i++ {
+			if byID[ids[i-1]].SKF64 < byID[ids[i]].SKF64 {
+				t.Errorf("partition %d is not sorted by descending SKF64: %v", p, ids)
+			}
+		}
+		got = append(got, ids...)
+	}
+	sort.Strings(got)
+	want := []string{}
+	for _, msg := range s.Msgs() {
+		if msg.User.ID == "user" {
+			want = append(want, msg.ID)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("scanned %v, want %v", got, want)
+	}
+
+	if _, err := c.MsgsByAppUser(ctx, "other-app", 0, ""); err == nil {
+		t.Errorf("no error of app of another token")
+	}
+	if _, err := c.MsgsByAppUser(ctx, "app", s.Partitions, ""); err == nil {
+		t.Errorf("no error of partition out of range")
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	s, c := newServer(t)
+	ctx := context.Background()
+	s.FailNext("/MsgCreate", 2, 503)
+	for i := 0; i < 2; i++ {
+		if _, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, ""); err == nil {
+			t.Errorf("no error of failed request %d", i)
+		}
+	}
+	// Faults of other paths are left alone.
+	if _, err := c.Me(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Msgs()); n != 0 {
+		t.Errorf("%d messages of failed requests", n)
+	}
+	if _, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailNextRetried(t *testing.T) {
+	s, _ := newServer(t)
+	c := s.Client(testToken, jinma.WithRetryPolicy(util.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}))
+	ctx := context.Background()
+	msg, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updates are retried until the faults run out.
+	s.FailNext("/MsgUpdate", 2, 503)
+	if _, err := c.MsgUpdate(ctx, msg.ID, []byte("new body"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.FailNext("/MsgUpdate", 3, 503)
+	_, err = c.MsgUpdate(ctx, msg.ID, []byte("newer body"), nil, nil)
+	if apiErr, ok := err.(*jinma.APIError); !ok || apiErr.StatusCode != 503 || apiErr.Code != jinmatest.CodeInjectedFault {
+		t.Errorf("got error %v, want the injected fault", err)
+	}
+	if got := s.Msgs()[0].Body; got != "new body" {
+		t.Errorf("body %s, want the body of the first update", got)
+	}
+}
+
+func TestNoRetries(t *testing.T) {
+	s, _ := newServer(t)
+	// A policy without attempts still makes one.
+	c := s.Client(testToken, jinma.WithRetryPolicy(util.RetryPolicy{}))
+	ctx := context.Background()
+	if _, err := c.Me(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.FailNext("/Me", 1, 503)
+	if _, err := c.Me(ctx); err == nil {
+		t.Errorf("no error of the failed request")
+	}
+}
+
+func TestDropCreateResponses(t *testing.T) {
+	s, c := newServer(t)
+	s.DropCreateResponses(1)
+	if _, err := c.MsgCreate(context.Background(), "body", 25, 121, nil, nil, "RPTEST0001"); err == nil {
+		t.Errorf("no error of dropped response")
+	}
+	if n := len(s.Msgs()); n != 1 {
+		t.Errorf("%d messages, want 1", n)
+	}
+}
+
+func TestDuplicateCustomIDs(t *testing.T) {
+	s, c := newServer(t)
+	ctx := context.Background()
+	// Like Jinma, the server allows duplicate CustomIDs by default.
+	for i := 0; i < 2; i++ {
+		if _, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, "RPTEST0001"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(s.Msgs()); n != 2 {
+		t.Errorf("%d messages, want 2", n)
+	}
+	s.AllowDuplicateCustomIDs(false)
+	if _, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, "RPTEST0002"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, "RPTEST0002"); err == nil {
+		t.Errorf("no error of duplicate CustomID")
+	}
+}
+
+func TestLoadMsgs(t *testing.T) {
+	s, c := newServer(t)
+	s.Partitions = 3
+	ctx := context.Background()
+	var b []byte
+	for i := 0; i < 5; i++ {
+		msg, err := c.MsgCreate(ctx, "body", 25, 121, nil, nil, fmt.Sprintf("RPTEST%04d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(append(b, line...), '\n')
+	}
+	dumpfile := filepath.Join(t.TempDir(), "msgs.jsonl")
+	if err := ioutil.WriteFile(dumpfile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fname := range []string{"", dumpfile} {
+		msgs, err := jinma.LoadMsgs(ctx, s.Client(testToken, jinma.WithPartitions(s.Partitions)), fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, msg := range msgs {
+			got = append(got, msg.ID)
+		}
+		sort.Strings(got)
+		want := []string{}
+		for _, msg := range s.Msgs() {
+			want = append(want, msg.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("loaded %v from %q, want %v", got, fname, want)
+		}
+	}
+}
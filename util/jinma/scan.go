@@ -0,0 +1,121 @@
+package jinma
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPartitions is the number of partitions of the messages of an app user.
+	DefaultPartitions = 1536
+)
+
+// ScanPartition calls fn for every message of the token's user in app appID and partition.
+func (c *Client) ScanPartition(ctx context.Context, appID string, partition int, fn func(Msg) error) error {
+	return c.ScanPartitionPages(ctx, appID, partition, "", func(msgs []Msg, esk string) error {
+		for _, msg := range msgs {
+			if err := fn(msg); err != nil {
+				return errors.Wrap(err, "handle msg function")
+			}
+		}
+		return nil
+	})
+}
+
+// ScanPartitionPages calls fn for every page of messages of the token's user in app appID and partition,
+// starting after the exclusive start key esk, or from the beginning if esk is empty.
+// fn also receives the key to resume the scan after the page, which is empty for the last page.
+func (c *Client) ScanPartitionPages(ctx context.Context, appID string, partition int, esk string, fn func(msgs []Msg, esk string) error) error {
+	for {
+		resp, err := c.MsgsByAppUser(ctx, appID, partition, esk)
+		if err != nil {
+			return errors.Wrap(err, "MsgsByAppUser")
+		}
+		esk = resp.LastEvaluatedKey
+		if err := fn(resp.Msgs, esk); err != nil {
+			return err
+		}
+		if esk == "" {
+			break
+		}
+	}
+	return nil
+}
+
+// ScanAllPartitions calls fn for every message of the token's user in app appID.
+func (c *Client) ScanAllPartitions(ctx context.Context, appID string, fn func(Msg) error) error {
+	for partition := 0; partition < c.Partitions; partition++ {
+		if err := c.ScanPartition(ctx, appID, partition, fn); err != nil {
+			if ctx.Err() != nil {
+				glog.Infof("interrupted while scanning partition %d", partition)
+			}
+			return errors.Wrap(err, fmt.Sprintf("ScanPartition %d", partition))
+		}
+		glog.Infof("finished scanning partition %d", partition)
+	}
+	return nil
+}
+
+// ScanDump calls fn for every message in fname, a dump written by cmd/get_msgs.
+func ScanDump(fname string, fn func(rowID int, msg Msg) error) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return errors.Wrap(err, "os.Open")
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	i := -1
+	for scanner.Scan() {
+		i += 1
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		msg := Msg{}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("json.Unmarshal msg %d", i))
+		}
+		if err := fn(i, msg); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "scanner.Err")
+	}
+	return nil
+}
+
+// LoadMsgs returns the messages in dumpfile, a dump written by cmd/get_msgs,
+// or if dumpfile is empty, the messages of the token's user scanned from its app.
+func LoadMsgs(ctx context.Context, client *Client, dumpfile string) ([]Msg, error) {
+	msgs := []Msg{}
+	if dumpfile != "" {
+		err := ScanDump(dumpfile, func(rowID int, msg Msg) error {
+			msgs = append(msgs, msg)
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "ScanDump")
+		}
+		return msgs, nil
+	}
+
+	me, err := client.Me(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "client.Me")
+	}
+	err = client.ScanAllPartitions(ctx, me.App.ID, func(msg Msg) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "client.ScanAllPartitions")
+	}
+	return msgs, nil
+}
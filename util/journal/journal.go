@@ -0,0 +1,155 @@
+// Package journal records the progress of batch jobs, so that they can resume after a crash.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Actions of Entries.
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionSkipped = "skipped"
+	// ActionReplaced follows the ActionCreated of a message that replaces another, once the other is deleted.
+	ActionReplaced = "replaced"
+
+	// ActionScanning and ActionScanned record the progress of partition scans.
+	ActionScanning = "scanning"
+	ActionScanned  = "scanned"
+)
+
+// Entry records a successfully processed input line.
+// The entries of partition scans are keyed by the partition in Line instead.
+type Entry struct {
+	Line     int
+	CustomID string `json:",omitempty"`
+	MsgID    string `json:",omitempty"`
+	Action   string `json:",omitempty"`
+	// ESK is the key to resume a partition scan from.
+	ESK string `json:",omitempty"`
+	// Offset is the size of the output of a partition scan.
+	Offset int64 `json:",omitempty"`
+	Time   int64
+}
+
+// Journal is an append-only file of Entries.
+// Every Append is synced to disk before it returns.
+type Journal struct {
+	mu         sync.Mutex
+	f          *os.File
+	byLine     map[int]Entry
+	byCustomID map[string]Entry
+}
+
+// Open reads the entries of fname and opens it for appending, creating it if necessary.
+// An incomplete last entry, left by a crash during Append, is discarded.
+func Open(fname string) (*Journal, error) {
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.OpenFile")
+	}
+	j := &Journal{
+		f:          f,
+		byLine:     make(map[int]Entry),
+		byCustomID: make(map[string]Entry),
+	}
+	if err := j.load(); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, fmt.Sprintf("load %s", fname))
+	}
+	return j, nil
+}
+
+func (j *Journal) load() error {
+	r := bufio.NewReader(j.f)
+	var size int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// line is an incomplete entry, which is truncated below.
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "ReadBytes")
+		}
+		e := Entry{}
+		if err := json.Unmarshal(bytes.TrimSpace(line), &e); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("json.Unmarshal %s", line))
+		}
+		j.add(e)
+		size += int64(len(line))
+	}
+	if err := j.f.Truncate(size); err != nil {
+		return errors.Wrap(err, "Truncate")
+	}
+	if _, err := j.f.Seek(size, io.SeekStart); err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+	return nil
+}
+
+func (j *Journal) add(e Entry) {
+	j.byLine[e.Line] = e
+	if e.CustomID != "" {
+		j.byCustomID[e.CustomID] = e
+	}
+}
+
+// Append durably records e.
+func (j *Journal) Append(e Entry) error {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	b = append(b, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(b); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	if err := j.f.Sync(); err != nil {
+		return errors.Wrap(err, "Sync")
+	}
+	j.add(e)
+	return nil
+}
+
+// Line returns the latest entry of an input line.
+func (j *Journal) Line(line int) (Entry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.byLine[line]
+	return e, ok
+}
+
+// CustomID returns the latest entry of a CustomID.
+func (j *Journal) CustomID(customID string) (Entry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.byCustomID[customID]
+	return e, ok
+}
+
+// Len returns the number of recorded input lines.
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.byLine)
+}
+
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimiter spaces out events to at most a fixed number per second.
+// A nil *RateLimiter does not limit.
+type RateLimiter struct {
+	ticker *time.Ticker
+}
+
+// NewRateLimiter returns a RateLimiter of perSecond events per second,
+// or nil if perSecond is not positive.
+func NewRateLimiter(perSecond float64) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / perSecond))}
+}
+
+// Wait blocks until the next event is allowed, or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *RateLimiter) Stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// Redact replaces the secrets in s, including their URL-encoded forms.
+func Redact(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		s = strings.Replace(s, secret, redacted, -1)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			s = strings.Replace(s, escaped, redacted, -1)
+		}
+	}
+	return s
+}
+
+// redactedError has the message of its cause with secrets redacted.
+// The cause is kept for errors.Cause, but is never formatted.
+type redactedError struct {
+	msg   string
+	cause error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Cause() error  { return e.cause }
+func (e *redactedError) Unwrap() error { return e.cause }
+
+// RedactError returns err, or an error with the secrets redacted from its message if it contains any.
+// errors.Cause of the returned error is still the cause of err.
+func RedactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if r := Redact(msg, secrets...); r != msg {
+		return &redactedError{msg: r, cause: err}
+	}
+	return err
+}